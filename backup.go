@@ -0,0 +1,78 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Backup 在线备份数据库到指定目录，备份目录可以直接通过 Open 打开
+// 只在切换活跃文件时持有锁，拷贝数据文件期间不影响写入
+func (db *DB) Backup(dir string) error {
+	// B+ 树索引存储在磁盘上，无法和数据文件保持一致的拷贝
+	if db.options.IndexType == BPlusTree {
+		return ErrBackupNotSupported
+	}
+
+	// 拷贝期间不能在线安装 merge 的结果，否则数据文件会被替换
+	db.mergeInstallMu.RLock()
+	defer db.mergeInstallMu.RUnlock()
+
+	db.mu.Lock()
+	// 持久化并切换活跃文件，之前的数据文件都不会再被修改
+	if !db.options.ReadOnly && db.activeFile != nil && db.activeFile.WriteOff > db.activeFile.HeaderSize {
+		if err := db.rotateActiveFile(); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+	// 记录需要拷贝的数据文件
+	var fileIds []uint32
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	// 只读模式下活跃文件也不会被修改
+	if db.options.ReadOnly && db.activeFile != nil {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	seqNo := db.seqNo
+	db.mu.Unlock()
+
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	if err := db.fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	// 拷贝不可变的数据文件
+	for _, fid := range fileIds {
+		src := data.GetDataFileName(db.options.DirPath, fid)
+		dest := data.GetDataFileName(dir, fid)
+		if err := utils.CopyFile(db.fs, src, dest); err != nil {
+			return err
+		}
+		// 增量 merge 生成的数据文件有对应的 hint 文件
+		hintSrc := data.GetDataHintFileName(db.options.DirPath, fid)
+		if _, err := db.fs.Stat(hintSrc); os.IsNotExist(err) {
+			continue
+		}
+		if err := utils.CopyFile(db.fs, hintSrc, data.GetDataHintFileName(dir, fid)); err != nil {
+			return err
+		}
+	}
+	// 拷贝 merge 生成的 hint 文件以及标识 merge 完成的文件
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		src := filepath.Join(db.options.DirPath, fileName)
+		if _, err := db.fs.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := utils.CopyFile(db.fs, src, filepath.Join(dir, fileName)); err != nil {
+			return err
+		}
+	}
+	// 保存备份时的事务序列号
+	return writeSeqNoFile(db.fs, dir, seqNo)
+}
@@ -22,10 +22,14 @@ type WriteBatch struct {
 
 // NewWriteBatch
 //
-//	@Description: 初始化WriteBatch
+//	@Description: 初始化WriteBatch，只读模式下返回 ErrReadOnly
 //	@receiver db
 //	@return *WriteBatch
-func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
+//	@return error
+func (db *DB) NewWriteBatch(opts WriteBatchOptions) (*WriteBatch, error) {
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
 	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
 		panic("cannot use write batch ,seq no file not exists")
 	}
@@ -34,7 +38,7 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		mu:            new(sync.Mutex),
 		db:            db,
 		pendingWrites: make(map[string]*data.LogRecord),
-	}
+	}, nil
 }
 
 // Put 批量写数据
@@ -76,13 +80,28 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
+	// 加锁保证事务提交的串行化
+	wb.db.mu.Lock()
+	defer wb.db.mu.Unlock()
+
+	if err := wb.db.commitTxnRecords(wb.pendingWrites); err != nil {
+		return err
+	}
+
+	//清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	return nil
+}
+
+// commitTxnRecords 将事务数据写到数据文件，并更新内存索引，在访问此方法之前必须持有互斥锁
+func (db *DB) commitTxnRecords(records map[string]*data.LogRecord) error {
 	// 获取当前最新得事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	//开始写数据到文件数据中
 	positions := make(map[string]*data.LogRecordPos)
-	for _, logRecord := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
+	for _, logRecord := range records {
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
 			Key:   logRecordKeyWithSeq(logRecord.Key, seqNo),
 			Value: logRecord.Value,
 			Type:  logRecord.Type,
@@ -98,30 +117,24 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	finishedPos, err := db.appendLogRecord(finishedRecord)
+	if err != nil {
 		return err
 	}
+	db.addGarbage(finishedPos.Fid, int64(finishedPos.Size))
 
 	//根据配置决定是否持久化
-	if wb.db.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if db.options.SyncWrites && db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return err
 		}
 	}
 
 	//更新内存索引
-	for _, logRecord := range wb.pendingWrites {
+	for _, logRecord := range records {
 		pos := positions[string(logRecord.Key)]
-		if logRecord.Type == data.LogRecordNormal {
-			wb.db.index.Put(logRecord.Key, pos)
-		}
-		if logRecord.Type == data.LogRecordDeleted {
-			wb.db.index.Delete(logRecord.Key)
-		}
+		db.updateIndex(logRecord.Key, logRecord.Type, pos)
 	}
-
-	//清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
 	return nil
 }
 
@@ -130,7 +143,7 @@ func logRecordKeyWithSeq(key []byte, seqNo uint64) []byte {
 	seq := make([]byte, binary.MaxVarintLen64)
 	n := binary.PutUvarint(seq[:], seqNo)
 	encKey := make([]byte, n+len(key))
-	copy(encKey[:n], key[:n])
+	copy(encKey[:n], seq[:n])
 	copy(encKey[n:], key)
 
 	return encKey
@@ -17,7 +17,8 @@ func TestDB_WriteBatch(t *testing.T) {
 	assert.NotNil(t, db)
 
 	// 写数据之后 并没有提交
-	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	wb, err := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
 	err = wb.Put(utils.GetTestKey(1), utils.RandomValue(10))
 	assert.Nil(t, err)
 	err = wb.Delete(utils.GetTestKey(2))
@@ -35,7 +36,8 @@ func TestDB_WriteBatch(t *testing.T) {
 	assert.Nil(t, err)
 
 	// 删除提交的数据
-	wb2 := db.NewWriteBatch(DefaultWriteBatchOptions)
+	wb2, err := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
 	err = wb2.Delete(utils.GetTestKey(1))
 	assert.Nil(t, err)
 	err = wb2.Commit()
@@ -58,7 +60,8 @@ func TestDB_WriteBatch2(t *testing.T) {
 	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
 	assert.Nil(t, err)
 
-	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	wb, err := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
 	err = wb.Put(utils.GetTestKey(2), utils.RandomValue(10))
 	assert.Nil(t, err)
 	err = wb.Delete(utils.GetTestKey(1))
@@ -104,7 +107,8 @@ func TestDB_WriteBatch3(t *testing.T) {
 
 	wbOpts := DefaultWriteBatchOptions
 	wbOpts.MaxBatchSize = 600000
-	wb := db.NewWriteBatch(wbOpts)
+	wb, err := db.NewWriteBatch(wbOpts)
+	assert.Nil(t, err)
 	for i := 0; i < 50000; i++ {
 		err = wb.Put(utils.GetTestKey(i), utils.RandomValue(1024))
 		assert.Nil(t, err)
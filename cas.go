@@ -0,0 +1,73 @@
+package bitcask_go
+
+import "bytes"
+
+// CompareAndSwap 当key存在且value等于expected时写入newValue，返回是否写入成功
+// 新写入的数据不会保留原有的过期时间
+func (db *DB) CompareAndSwap(key, expected, newValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(value, expected) {
+		return false, nil
+	}
+	if err := db.put(key, newValue, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 当key不存在（或已过期）时写入value，返回是否写入成功
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.get(key)
+	if err == nil {
+		return false, nil
+	}
+	if err != ErrKeyNotFound {
+		return false, err
+	}
+	if err := db.put(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfEquals 当key存在且value等于expected时删除key，返回是否删除成功
+func (db *DB) DeleteIfEquals(key, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(value, expected) {
+		return false, nil
+	}
+	if err := db.delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// BlockSize 缓存的数据块大小
+const BlockSize = 4096
+
+const blockCacheShards = 16
+
+// BlockCache 数据块缓存，按照数据文件和块的位置缓存数据文件中固定大小的数据块
+// 分为多个分片，每个分片有自己的锁和 LRU 链表，减少并发读取时的锁竞争
+type BlockCache struct {
+	shards [blockCacheShards]*blockCacheShard
+	hits   uint64
+	misses uint64
+}
+
+// BlockCacheStats 数据块缓存统计信息
+type BlockCacheStats struct {
+	Hits   uint64 // 命中次数
+	Misses uint64 // 没有命中的次数
+	Size   int64  // 当前缓存的数据量，字节为单位
+}
+
+type blockKey struct {
+	fileId uint64
+	block  int64
+}
+
+type blockEntry struct {
+	key  blockKey
+	data []byte
+}
+
+type blockCacheShard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      *list.List
+	blocks   map[blockKey]*list.Element
+}
+
+// NewBlockCache 初始化数据块缓存，capacity 为缓存的最大字节数
+func NewBlockCache(capacity int64) *BlockCache {
+	cache := &BlockCache{}
+	for i := range cache.shards {
+		cache.shards[i] = &blockCacheShard{
+			capacity: capacity / blockCacheShards,
+			lru:      list.New(),
+			blocks:   make(map[blockKey]*list.Element),
+		}
+	}
+	return cache
+}
+
+// Stats 返回缓存的统计信息
+func (c *BlockCache) Stats() BlockCacheStats {
+	var size int64
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		size += shard.size
+		shard.mu.Unlock()
+	}
+	return BlockCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+}
+
+func (c *BlockCache) shard(key blockKey) *blockCacheShard {
+	h := key.fileId*31 + uint64(key.block)
+	return c.shards[h%blockCacheShards]
+}
+
+func (c *BlockCache) get(key blockKey) ([]byte, bool) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	elem, ok := shard.blocks[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	shard.lru.MoveToFront(elem)
+	return elem.Value.(*blockEntry).data, true
+}
+
+func (c *BlockCache) put(key blockKey, data []byte) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if int64(len(data)) > shard.capacity {
+		return
+	}
+	if _, ok := shard.blocks[key]; ok {
+		return
+	}
+	shard.blocks[key] = shard.lru.PushFront(&blockEntry{key: key, data: data})
+	shard.size += int64(len(data))
+
+	// 超出容量时淘汰最久没有访问的数据块
+	for shard.size > shard.capacity {
+		elem := shard.lru.Back()
+		entry := elem.Value.(*blockEntry)
+		shard.lru.Remove(elem)
+		delete(shard.blocks, entry.key)
+		shard.size -= int64(len(entry.data))
+	}
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	cache := NewBlockCache(blockCacheShards * BlockSize * 2)
+
+	key := blockKey{fileId: 1, block: 0}
+	_, ok := cache.get(key)
+	assert.False(t, ok)
+	cache.put(key, make([]byte, BlockSize))
+	_, ok = cache.get(key)
+	assert.True(t, ok)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, int64(BlockSize), stats.Size)
+
+	// 同一个分片中超出容量时淘汰最久没有访问的数据块
+	shard := cache.shard(key)
+	var keys []blockKey
+	for block := int64(1); len(keys) < 2; block++ {
+		k := blockKey{fileId: 1, block: block}
+		if cache.shard(k) == shard {
+			keys = append(keys, k)
+		}
+	}
+	cache.put(keys[0], make([]byte, BlockSize))
+	_, ok = cache.get(key)
+	assert.True(t, ok)
+	cache.put(keys[1], make([]byte, BlockSize))
+
+	_, ok = cache.get(keys[0])
+	assert.False(t, ok)
+	_, ok = cache.get(key)
+	assert.True(t, ok)
+	_, ok = cache.get(keys[1])
+	assert.True(t, ok)
+	assert.True(t, cache.Stats().Size <= int64(blockCacheShards*BlockSize*2))
+}
+
+func TestDataFile_ReadLogRecord_BlockCache(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-block-cache")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	cache := NewBlockCache(1024 * 1024)
+	dataFile.SetBlockCache(cache)
+
+	var offsets []int64
+	var records []*LogRecord
+	for i := 0; i < 100; i++ {
+		rec := &LogRecord{Key: []byte("name"), Value: make([]byte, 100+i)}
+		rec.Value[0] = byte(i)
+		enc, _ := EncodeLogRecord(rec)
+		offsets = append(offsets, dataFile.WriteOff)
+		records = append(records, rec)
+		err = dataFile.Write(enc)
+		assert.Nil(t, err)
+	}
+
+	for round := 0; round < 2; round++ {
+		for i, offset := range offsets {
+			readRec, _, err := dataFile.ReadLogRecord(offset)
+			assert.Nil(t, err)
+			assert.Equal(t, records[i].Value, readRec.Value)
+		}
+	}
+	stats := cache.Stats()
+	assert.True(t, stats.Hits > 0)
+	assert.True(t, stats.Size > 0)
+	// 最后一个不完整的数据块不会被缓存
+	assert.True(t, stats.Size <= dataFile.WriteOff)
+	assert.Equal(t, int64(0), stats.Size%BlockSize)
+}
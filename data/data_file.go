@@ -7,6 +7,7 @@ import (
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync/atomic"
 )
 
 var (
@@ -15,61 +16,125 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	DataHintFileSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	SeqNoTempFileName     = "seq-no.tmp"
+	GarbageFileName       = "garbage"
+	GarbageTempFileName   = "garbage.tmp"
 )
 
 // DataFile
 // @Description: 磁盘上的数据文件
 type DataFile struct {
-	FileId    uint32
-	WriteOff  int64
-	IoManager fio.IOManager
+	FileId     uint32
+	WriteOff   int64
+	HeaderSize int64 // 文件头部的大小，即第一条 LogRecord 的位置，旧版本的文件没有头部
+	IoManager  fio.IOManager
+	cacheId    uint64      // 在数据块缓存中的唯一标识，重新打开的文件使用新的标识
+	blockCache *BlockCache // 数据块缓存，为空表示不使用缓存
 }
 
+// 数据文件在数据块缓存中的标识
+var nextCacheId uint64
+
 // OpenDataFile 打开新的数据文件
-func OpenDataFile(dirPath string, fileId uint32) (*DataFile, error) {
+func OpenDataFile(fs fio.FS, dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId)
+	return newDataFile(fs, fileName, fileId, ioType, DataFileKind)
 }
 
-func OpenHintFile(dirpath string) (*DataFile, error) {
+func OpenHintFile(fs fio.FS, dirpath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, HintFileName)
-	return newDataFile(fileName, 0)
+	return newDataFile(fs, fileName, 0, ioType, HintFileKind)
+}
+
+// OpenDataHintFile 打开数据文件对应的 hint 文件，保存了增量 merge 生成的数据文件中的索引信息
+func OpenDataHintFile(fs fio.FS, dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetDataHintFileName(dirPath, fileId)
+	return newDataFile(fs, fileName, fileId, ioType, HintFileKind)
 }
 
-func OpenMergeFinishedFile(dirpath string) (*DataFile, error) {
+func OpenMergeFinishedFile(fs fio.FS, dirpath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, MergeFinishedFileName)
-	return newDataFile(fileName, 0)
+	return newDataFile(fs, fileName, 0, ioType, MergeFinishedFileKind)
 }
 
-func OpenSeqNoFile(dirpath string) (*DataFile, error) {
+func OpenSeqNoFile(fs fio.FS, dirpath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, SeqNoFileName)
-	return newDataFile(fileName, 0)
+	return newDataFile(fs, fileName, 0, ioType, SeqNoFileKind)
+}
+
+// OpenSeqNoTempFile 打开写入事务序列号的临时文件，写完并持久化之后重命名为 seqnofile
+func OpenSeqNoTempFile(fs fio.FS, dirpath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirpath, SeqNoTempFileName)
+	return newDataFile(fs, fileName, 0, ioType, SeqNoFileKind)
+}
+
+// OpenGarbageFile 打开保存每个数据文件中可回收数据量的文件，B+ 树索引在重启时不加载数据文件，从这里恢复统计信息
+func OpenGarbageFile(fs fio.FS, dirpath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirpath, GarbageFileName)
+	return newDataFile(fs, fileName, 0, ioType, GarbageFileKind)
+}
+
+// OpenGarbageTempFile 打开写入可回收数据量的临时文件，写完并持久化之后重命名
+func OpenGarbageTempFile(fs fio.FS, dirpath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirpath, GarbageTempFileName)
+	return newDataFile(fs, fileName, 0, ioType, GarbageFileKind)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
-	ioManager, err := fio.NewIOManager(fileName)
+func GetDataHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataHintFileSuffix)
+}
+
+func newDataFile(fs fio.FS, fileName string, fileId uint32, ioType fio.FileIOType, kind FileKind) (*DataFile, error) {
+	ioManager, err := fs.OpenFile(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{
+	dataFile := &DataFile{
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
-	}, nil
+		cacheId:   atomic.AddUint64(&nextCacheId, 1),
+	}
+	fileSize, err := ioManager.Size()
+	if err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+
+	// 新建的文件写入头部，已有的文件校验头部
+	if fileSize == 0 && fio.Writable(ioType) {
+		err = dataFile.Write(encodeFileHeader(kind))
+	} else {
+		dataFile.WriteOff, err = dataFile.readFileHeader(fileSize, kind)
+	}
+	if err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	dataFile.HeaderSize = dataFile.WriteOff
+	return dataFile, nil
 }
+
+// ReadLogRecord 根据 offset 读取 LogRecord
+// 读到文件末尾时返回 io.EOF，数据不完整时返回 io.ErrUnexpectedEOF，
+// 校验失败时返回 ErrInvalidCRC 以及这条数据的长度
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
 		return nil, 0, err
 	}
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
 
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
@@ -84,7 +149,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	//所以要把多读出来的header处理一下，返回实际的header和长度
 	header, headerSize := decodeLogRecordHeader(headerBuf)
 	if header == nil {
-		return nil, 0, io.EOF
+		return nil, 0, io.ErrUnexpectedEOF
 	}
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
 		return nil, 0, io.EOF
@@ -93,8 +158,11 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	//取出对应的key和value的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	if offset+recordSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-	logRecord := &LogRecord{Type: header.recordType}
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire}
 
 	//开始读取用户实际存储的key、value数据
 	if keySize > 0 || valueSize > 0 {
@@ -110,7 +178,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	//校验数据的有效性，原理是拿磁盘上LogRecord中除了头部header crc字段之后求crc值，判断和直接从磁盘上取出来的crc值是否相同
 	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		return nil, recordSize, ErrInvalidCRC
 	}
 	return logRecord, recordSize, nil
 }
@@ -124,6 +192,10 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
+		// 只写入了一部分，截断回写入之前的位置，避免后续的数据和索引中的位置对不上
+		if n > 0 {
+			_ = df.IoManager.Truncate(df.WriteOff)
+		}
 		return err
 	}
 	df.WriteOff += int64(n)
@@ -140,6 +212,59 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	return df.Write(encRecord)
 }
 
+// WriteDeletedHintRecord 写入删除标记的索引信息到hintfile，加载时从索引中删除对应的 key
+func (df *DataFile) WriteDeletedHintRecord(key []byte, pos *LogRecordPos) error {
+	record := &LogRecord{
+		Key:   key,
+		Value: EncodeLogRecordPos(pos),
+		Type:  LogRecordDeleted,
+	}
+	encRecord, _ := EncodeLogRecord(record)
+	return df.Write(encRecord)
+}
+
+// Preallocate 预先分配文件空间，文件末尾没有写入数据的部分填充为 0
+func (df *DataFile) Preallocate(size int64) error {
+	return df.IoManager.Preallocate(size)
+}
+
+// Trim 截掉文件末尾预分配的空间
+func (df *DataFile) Trim() error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= df.WriteOff {
+		return nil
+	}
+	return df.IoManager.Truncate(df.WriteOff)
+}
+
+// IsZeroFrom 判断文件从 offset 开始到末尾是否全部为 0，即预分配之后没有写入的空间
+func (df *DataFile) IsZeroFrom(offset int64) (bool, error) {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	buf := make([]byte, 64*1024)
+	for offset < size {
+		n := int64(len(buf))
+		if offset+n > size {
+			n = size - offset
+		}
+		if _, err := df.IoManager.Read(buf[:n], offset); err != nil {
+			return false, err
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		offset += n
+	}
+	return true, nil
+}
+
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
@@ -148,8 +273,46 @@ func (df *DataFile) Close() error {
 	return df.IoManager.Close()
 }
 
+// SetBlockCache 设置读取数据时使用的数据块缓存
+func (df *DataFile) SetBlockCache(cache *BlockCache) {
+	df.blockCache = cache
+}
+
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
-	_, err = df.IoManager.Read(b, offset)
+	if df.blockCache == nil {
+		_, err = df.IoManager.Read(b, offset)
+		return
+	}
+
+	var copied int64
+	for copied < n {
+		pos := offset + copied
+		blockStart := pos / BlockSize * BlockSize
+		// WriteOff 之后的数据还可能发生变化，直接读取不缓存
+		if blockStart+BlockSize > df.WriteOff {
+			_, err = df.IoManager.Read(b[copied:], pos)
+			return
+		}
+		block, err := df.readBlock(blockStart)
+		if err != nil {
+			return nil, err
+		}
+		copied += int64(copy(b[copied:], block[pos-blockStart:]))
+	}
 	return
 }
+
+// readBlock 读取 blockStart 开始的一个完整的数据块，优先从缓存中获取
+func (df *DataFile) readBlock(blockStart int64) ([]byte, error) {
+	key := blockKey{fileId: df.cacheId, block: blockStart / BlockSize}
+	if block, ok := df.blockCache.get(key); ok {
+		return block, nil
+	}
+	block := make([]byte, BlockSize)
+	if _, err := df.IoManager.Read(block, blockStart); err != nil {
+		return nil, err
+	}
+	df.blockCache.put(key, block)
+	return block, nil
+}
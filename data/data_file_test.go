@@ -1,28 +1,30 @@
 package data
 
 import (
+	"bitcask-go/fio"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
 )
 
 func TestOpenDataFile(t *testing.T) {
 
-	dataFile1, err := OpenDataFile(os.TempDir(), 0)
+	dataFile1, err := OpenDataFile(fio.OSFS, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 111)
+	dataFile2, err := OpenDataFile(fio.OSFS, os.TempDir(), 111, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(os.TempDir(), 111)
+	dataFile3, err := OpenDataFile(fio.OSFS, os.TempDir(), 111, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0)
+	dataFile, err := OpenDataFile(fio.OSFS, os.TempDir(), 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -37,7 +39,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123)
+	dataFile, err := OpenDataFile(fio.OSFS, os.TempDir(), 123, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -49,7 +51,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 456)
+	dataFile, err := OpenDataFile(fio.OSFS, os.TempDir(), 456, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -61,7 +63,7 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 //func TestDataFile_Read(t *testing.T) {
-//	dataFile, err := OpenDataFile(os.TempDir(), 12345)
+//	dataFile, err := OpenDataFile(fio.OSFS, os.TempDir(), 12345, fio.StandardFIO)
 //	assert.Nil(t, err)
 //	assert.NotNil(t, dataFile)
 //
@@ -107,3 +109,49 @@ func TestDataFile_Sync(t *testing.T) {
 //	assert.Equal(t, rec3, readRec3)
 //	assert.Equal(t, size3, readSize3)
 //}
+
+func TestDataFile_ReadLogRecord_Torn(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	base := dataFile.HeaderSize
+
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	enc1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(enc1)
+	assert.Nil(t, err)
+
+	// 只写入了一部分的数据
+	rec2 := &LogRecord{Key: []byte("name"), Value: []byte("a new value")}
+	enc2, size2 := EncodeLogRecord(rec2)
+	err = dataFile.Write(enc2[:size2-3])
+	assert.Nil(t, err)
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(base)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1.Value, readRec1.Value)
+	assert.Equal(t, size1, readSize1)
+
+	_, _, err = dataFile.ReadLogRecord(base + size1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// header 也不完整
+	err = dataFile.IoManager.Truncate(base + size1 + 2)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(base + size1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 长度完整但是内容被破坏
+	err = dataFile.IoManager.Truncate(base + size1)
+	assert.Nil(t, err)
+	enc2[size2-1] ^= 0xff
+	err = dataFile.Write(enc2)
+	assert.Nil(t, err)
+	_, readSize2, err := dataFile.ReadLogRecord(base + size1)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Equal(t, size2, readSize2)
+
+	_, _, err = dataFile.ReadLogRecord(base + size1 + size2)
+	assert.Equal(t, io.EOF, err)
+}
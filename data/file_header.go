@@ -0,0 +1,71 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidFileHeader      = errors.New("invalid data file header")
+	ErrUnsupportedFileVersion = errors.New("unsupported data file format version")
+	ErrFileKindMismatch       = errors.New("data file kind mismatch")
+)
+
+type FileKind = byte
+
+const (
+	DataFileKind FileKind = iota + 1
+	HintFileKind
+	MergeFinishedFileKind
+	SeqNoFileKind
+	GarbageFileKind
+)
+
+// FileFormatVersion 当前的文件格式版本，LogRecord 的编码发生变化时递增
+const FileFormatVersion byte = 1
+
+// 文件头部
+// +------------+------------+------------+------------+
+// / magic 魔数  /  version   /  kind 类型  /   保留字段   /
+// +------------+------------+------------+------------+
+//
+//	4字节        1字节        1字节         2字节
+const fileHeaderSize = 8
+
+var fileHeaderMagic = []byte("BKGO")
+
+// encodeFileHeader 编码文件头部
+func encodeFileHeader(kind FileKind) []byte {
+	header := make([]byte, fileHeaderSize)
+	copy(header[:4], fileHeaderMagic)
+	header[4] = FileFormatVersion
+	header[5] = kind
+	return header
+}
+
+// readFileHeader 读取并校验文件头部，返回数据开始的位置
+// 旧版本的文件没有头部，数据从 0 开始
+func (df *DataFile) readFileHeader(fileSize int64, kind FileKind) (int64, error) {
+	if fileSize < int64(len(fileHeaderMagic)) {
+		return 0, nil
+	}
+	header := make([]byte, fileHeaderSize)
+	n, err := df.IoManager.Read(header, 0)
+	if n < len(fileHeaderMagic) {
+		return 0, err
+	}
+	if !bytes.Equal(header[:4], fileHeaderMagic) {
+		return 0, nil
+	}
+	if n < fileHeaderSize {
+		return 0, ErrInvalidFileHeader
+	}
+	if header[4] > FileFormatVersion {
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedFileVersion, header[4])
+	}
+	if header[5] != kind {
+		return 0, ErrFileKindMismatch
+	}
+	return fileHeaderSize, nil
+}
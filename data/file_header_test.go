@@ -0,0 +1,76 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataFile_FileHeader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-header")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(fileHeaderSize), dataFile.HeaderSize)
+	assert.Equal(t, int64(fileHeaderSize), dataFile.WriteOff)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	enc, size := EncodeLogRecord(rec)
+	err = dataFile.Write(enc)
+	assert.Nil(t, err)
+	err = dataFile.Close()
+	assert.Nil(t, err)
+
+	// 重新打开时校验头部
+	dataFile, err = OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(fileHeaderSize), dataFile.HeaderSize)
+	readRec, readSize, err := dataFile.ReadLogRecord(dataFile.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Value, readRec.Value)
+	assert.Equal(t, size, readSize)
+	err = dataFile.Close()
+	assert.Nil(t, err)
+
+	// 文件类型不匹配
+	err = os.Rename(GetDataFileName(dir, 0), filepath.Join(dir, HintFileName))
+	assert.Nil(t, err)
+	_, err = OpenHintFile(fio.OSFS, dir, fio.StandardFIO)
+	assert.Equal(t, ErrFileKindMismatch, err)
+}
+
+func TestDataFile_FileHeader_Legacy(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-header")
+	defer os.RemoveAll(dir)
+
+	// 旧版本的文件没有头部
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	enc, size := EncodeLogRecord(rec)
+	err := os.WriteFile(GetDataFileName(dir, 0), enc, 0644)
+	assert.Nil(t, err)
+
+	dataFile, err := OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), dataFile.HeaderSize)
+	readRec, readSize, err := dataFile.ReadLogRecord(dataFile.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Value, readRec.Value)
+	assert.Equal(t, size, readSize)
+	_ = dataFile.Close()
+}
+
+func TestDataFile_FileHeader_UnsupportedVersion(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-header")
+	defer os.RemoveAll(dir)
+
+	header := encodeFileHeader(DataFileKind)
+	header[4] = FileFormatVersion + 1
+	err := os.WriteFile(GetDataFileName(dir, 0), header, 0644)
+	assert.Nil(t, err)
+
+	_, err = OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.ErrorIs(t, err, ErrUnsupportedFileVersion)
+}
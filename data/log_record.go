@@ -13,15 +13,19 @@ const (
 	LogRecordTxnFinished
 )
 
-// crc type keySize valueSize
-// 4 + 1 + 5 + 5 = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// crc type keySize valueSize expire
+// 4 + 1 + 5 + 5 + 10 = 25
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5
+
+// type 字段的最高位标识 header 中是否带有过期时间，旧版本的编码中没有该字段
+const logRecordExpireFlag byte = 0x80
 
 // LogRecord 写入到数据文件的记录，之所以叫日志是应为是以追加写的方式写入的，类似日志
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间，UnixNano 时间戳，0 表示永不过期
 }
 
 // LogRecord 的头部信息
@@ -30,12 +34,20 @@ type logRecordHeader struct {
 	recordType LogRecordType
 	keySize    uint32
 	valueSize  uint32
+	expire     int64
 }
 
 // LogRecordPos 记录了LogRecord的位置，他是放在磁盘上的
 type LogRecordPos struct {
-	Fid    uint32
-	Offset int64
+	Fid    uint32 // 文件 id，表示数据存储到了哪个文件当中
+	Offset int64  // 偏移，表示数据存储到了数据文件中的哪个位置
+	Size   uint32 // 标识数据在磁盘上的大小
+	Expire int64  // 过期时间，UnixNano 时间戳，0 表示永不过期
+}
+
+// IsExpired 判断数据在指定时间是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
 }
 
 // TransactionRecord 暂存事务香港得数据
@@ -45,22 +57,28 @@ type TransactionRecord struct {
 }
 
 // EncodeLogRecord 对 LogRecord 进行编码，返回字节数组以及长度
-// +-----------+------------+-------------+--------------+-----------+---------------+
-// / crc 校验值 /  type 类型  /  key size   /  value size  /    key    /     value     /
-// +-----------+------------+-------------+--------------+-----------+---------------+
+// +-----------+------------+-------------+--------------+---------------+-----------+---------------+
+// / crc 校验值 /  type 类型  /  key size   /  value size  / expire 过期时间 /    key    /     value     /
+// +-----------+------------+-------------+--------------+---------------+-----------+---------------+
 //
-//	4字节 		 1字节	     变长（最大5）	 变长（最大5）       变长			变长
+//	4字节 		 1字节	     变长（最大5）	 变长（最大5）   变长（最大10，可选）     变长			变长
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 初始化一个header部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
-	//第五个字节存储Type
+	//第五个字节存储Type，带有过期时间时设置标识位
 	header[4] = logRecord.Type
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+	}
 	var index = 5
 
 	//第五个字节之后存储key和value的长度信息
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
 
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
@@ -80,21 +98,37 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 }
 
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire > 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
 	return buf[:index]
 }
 
+// DecodeLogRecordPos 解码位置信息，旧版本编码中没有 size 和 expire 字段，此时均为 0
 func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	var size, expire int64
+	if index < len(buf) {
+		size, n = binary.Varint(buf[index:])
+		index += n
+	}
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:])
+	}
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
+		Size:   uint32(size),
+		Expire: expire,
 	}
 }
 
@@ -102,8 +136,8 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 //
 //	@Description: 对字节数组中的Header信息进行解码
 //	@param buf 从磁盘中读取到的LogRecord的头部信息字节数组
-//	@return *logRecordHeader
-//	@return int64 header的实际大小。包括crc+type+keysize+valuesize
+//	@return *logRecordHeader 数据不完整时返回 nil
+//	@return int64 header的实际大小。包括crc+type+keysize+valuesize+expire
 func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	if len(buf) <= 4 {
@@ -111,21 +145,37 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	}
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ logRecordExpireFlag,
 	}
 
 	var index = 5
 
 	// 取出实际的key size
 	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	// 取出实际的value size
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 设置了标识位才有过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.expire = expire
+		index += n
+	}
+
 	return header, int64(index)
 }
 
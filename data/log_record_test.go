@@ -96,3 +96,39 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc3)
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1713369600000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.NotNil(t, res)
+	assert.Equal(t, LogRecordNormal|logRecordExpireFlag, res[4])
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, n, size+int64(len(rec.Key)+len(rec.Value)))
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.Equal(t, rec.Expire, h.expire)
+	assert.Equal(t, uint32(4), h.keySize)
+	assert.Equal(t, uint32(10), h.valueSize)
+
+	crc := getLogRecordCRC(rec, res[crc32.Size:size])
+	assert.Equal(t, h.crc, crc)
+}
+
+func TestDecodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 5, Offset: 1024, Size: 77, Expire: 1713369600000000000}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+
+	// 旧版本的编码只有 fid 和 offset
+	buf := []byte{10, 128, 16}
+	oldPos := DecodeLogRecordPos(buf)
+	assert.Equal(t, uint32(5), oldPos.Fid)
+	assert.Equal(t, int64(1024), oldPos.Offset)
+	assert.Equal(t, uint32(0), oldPos.Size)
+	assert.Equal(t, int64(0), oldPos.Expire)
+}
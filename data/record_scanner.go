@@ -0,0 +1,124 @@
+package data
+
+import (
+	"hash/crc32"
+	"io"
+)
+
+// 顺序读取时每次从文件中读取的数据量
+const scanBufferSize = 1 << 20
+
+// RecordScanner 顺序读取数据文件中的 LogRecord
+// 每次从文件中读取一大块数据到缓冲区中，再从缓冲区中解码，适合 merge 和启动加载索引时遍历整个文件
+// 读取的结果和 ReadLogRecord 一致，但是不经过数据块缓存
+type RecordScanner struct {
+	df       *DataFile
+	fileSize int64
+	buf      []byte
+	bufStart int64 // 缓冲区第一个字节在文件中的位置
+	offset   int64 // 下一条 LogRecord 的位置
+}
+
+// NewRecordScanner 从 offset 开始顺序读取数据文件，文件大小在创建时确定，之后写入的数据读取不到
+func NewRecordScanner(df *DataFile, offset int64) (*RecordScanner, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &RecordScanner{
+		df:       df,
+		fileSize: fileSize,
+		bufStart: offset,
+		offset:   offset,
+	}, nil
+}
+
+// Offset 返回下一条 LogRecord 的位置，读取结束之后即最后一条完整数据的结束位置
+func (s *RecordScanner) Offset() int64 {
+	return s.offset
+}
+
+// Next 读取下一条 LogRecord，返回数据、数据所在的位置和长度
+// 读到文件末尾时返回 io.EOF，数据不完整时返回 io.ErrUnexpectedEOF，
+// 校验失败时返回 ErrInvalidCRC 以及这条数据的长度，出错时不会移动读取的位置
+func (s *RecordScanner) Next() (*LogRecord, int64, int64, error) {
+	offset := s.offset
+	if offset >= s.fileSize {
+		return nil, offset, 0, io.EOF
+	}
+
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if offset+maxLogRecordHeaderSize > s.fileSize {
+		headerBytes = s.fileSize - offset
+	}
+	headerBuf, err := s.peek(offset, headerBytes)
+	if err != nil {
+		return nil, offset, 0, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil {
+		return nil, offset, 0, io.ErrUnexpectedEOF
+	}
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, offset, 0, io.EOF
+	}
+
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	var recordSize = headerSize + keySize + valueSize
+	if offset+recordSize > s.fileSize {
+		return nil, offset, 0, io.ErrUnexpectedEOF
+	}
+	recordBuf, err := s.peek(offset, recordSize)
+	if err != nil {
+		return nil, offset, 0, err
+	}
+
+	// 缓冲区会被复用，key 和 value 需要拷贝出来
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire}
+	if keySize > 0 || valueSize > 0 {
+		kvBuf := make([]byte, keySize+valueSize)
+		copy(kvBuf, recordBuf[headerSize:])
+		logRecord.Key = kvBuf[:keySize]
+		logRecord.Value = kvBuf[keySize:]
+	}
+
+	crc := getLogRecordCRC(logRecord, recordBuf[crc32.Size:headerSize])
+	if crc != header.crc {
+		return nil, offset, recordSize, ErrInvalidCRC
+	}
+	s.offset += recordSize
+	return logRecord, offset, recordSize, nil
+}
+
+// peek 返回文件中 [offset, offset+n) 的数据，缓冲区中没有的部分从文件中读取
+func (s *RecordScanner) peek(offset, n int64) ([]byte, error) {
+	bufEnd := s.bufStart + int64(len(s.buf))
+	if offset >= s.bufStart && offset+n <= bufEnd {
+		return s.buf[offset-s.bufStart : offset-s.bufStart+n], nil
+	}
+
+	// 保留缓冲区中还没有用到的数据，从它之后继续读取
+	var kept []byte
+	if offset >= s.bufStart && offset < bufEnd {
+		kept = s.buf[offset-s.bufStart:]
+	}
+	readSize := int64(scanBufferSize)
+	if n > readSize {
+		readSize = n
+	}
+	if offset+readSize > s.fileSize {
+		readSize = s.fileSize - offset
+	}
+	buf := s.buf
+	if int64(cap(buf)) < readSize {
+		buf = make([]byte, readSize)
+	}
+	buf = buf[:readSize]
+	copy(buf, kept)
+	if _, err := s.df.IoManager.Read(buf[len(kept):], offset+int64(len(kept))); err != nil {
+		return nil, err
+	}
+	s.buf = buf
+	s.bufStart = offset
+	return s.buf[:n], nil
+}
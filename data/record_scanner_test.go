@@ -0,0 +1,103 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestRecordScanner(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-scanner")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	var records []*LogRecord
+	var offsets []int64
+	for i := 0; i < 1000; i++ {
+		rec := &LogRecord{Key: []byte{byte(i), byte(i >> 8)}, Value: make([]byte, 2000+i)}
+		rec.Value[0] = byte(i)
+		// 比缓冲区还大的数据
+		if i == 500 {
+			rec.Value = make([]byte, scanBufferSize*2)
+		}
+		enc, _ := EncodeLogRecord(rec)
+		offsets = append(offsets, dataFile.WriteOff)
+		records = append(records, rec)
+		err := dataFile.Write(enc)
+		assert.Nil(t, err)
+	}
+
+	scanner, err := NewRecordScanner(dataFile, dataFile.HeaderSize)
+	assert.Nil(t, err)
+	for i := range records {
+		rec, offset, size, err := scanner.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, offsets[i], offset)
+		assert.Equal(t, records[i].Key, rec.Key)
+		assert.Equal(t, records[i].Value, rec.Value)
+
+		readRec, readSize, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, readRec, rec)
+		assert.Equal(t, readSize, size)
+	}
+	_, _, _, err = scanner.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, dataFile.WriteOff, scanner.Offset())
+}
+
+func TestRecordScanner_Torn(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-scanner")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(fio.OSFS, dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	base := dataFile.HeaderSize
+
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	enc1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(enc1)
+	assert.Nil(t, err)
+
+	// 校验失败，返回数据的长度并且不移动读取的位置
+	rec2 := &LogRecord{Key: []byte("name"), Value: []byte("a new value")}
+	enc2, size2 := EncodeLogRecord(rec2)
+	enc2[size2-1] ^= 0xff
+	err = dataFile.Write(enc2)
+	assert.Nil(t, err)
+
+	scanner, err := NewRecordScanner(dataFile, base)
+	assert.Nil(t, err)
+	_, _, _, err = scanner.Next()
+	assert.Nil(t, err)
+	_, offset, size, err := scanner.Next()
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Equal(t, base+size1, offset)
+	assert.Equal(t, size2, size)
+	assert.Equal(t, base+size1, scanner.Offset())
+
+	// 只写入了一部分的数据
+	err = dataFile.IoManager.Truncate(base + size1 + size2 - 3)
+	assert.Nil(t, err)
+	scanner, err = NewRecordScanner(dataFile, base+size1)
+	assert.Nil(t, err)
+	_, _, _, err = scanner.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 预分配的空间全部为 0，视为文件末尾
+	err = dataFile.IoManager.Truncate(base + size1)
+	assert.Nil(t, err)
+	err = dataFile.Preallocate(4096)
+	assert.Nil(t, err)
+	scanner, err = NewRecordScanner(dataFile, base)
+	assert.Nil(t, err)
+	_, _, _, err = scanner.Next()
+	assert.Nil(t, err)
+	_, _, _, err = scanner.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, base+size1, scanner.Offset())
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const valueCacheShards = 16
+
+// 每条缓存数据额外占用的空间，用于估算缓存的大小
+const valueEntryOverhead = 64
+
+// ValueCache 用户数据缓存，按照数据在磁盘上的位置缓存读取到的 value
+// 数据写入之后位置不会再变化，key 被更新或删除之后会写到新的位置，所以缓存不需要主动失效
+type ValueCache struct {
+	shards [valueCacheShards]*valueCacheShard
+	hits   uint64
+	misses uint64
+}
+
+// ValueCacheStats 用户数据缓存统计信息
+type ValueCacheStats struct {
+	Hits   uint64 // 命中次数
+	Misses uint64 // 没有命中的次数
+	Size   int64  // 当前缓存的数据量，字节为单位
+}
+
+type valueKey struct {
+	fid    uint32
+	offset int64
+}
+
+type valueEntry struct {
+	key   valueKey
+	value []byte
+}
+
+type valueCacheShard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      *list.List
+	values   map[valueKey]*list.Element
+}
+
+// NewValueCache 初始化用户数据缓存，capacity 为缓存的最大字节数
+func NewValueCache(capacity int64) *ValueCache {
+	cache := &ValueCache{}
+	for i := range cache.shards {
+		cache.shards[i] = &valueCacheShard{
+			capacity: capacity / valueCacheShards,
+			lru:      list.New(),
+			values:   make(map[valueKey]*list.Element),
+		}
+	}
+	return cache
+}
+
+// Get 根据位置获取缓存的 value，返回的是缓存数据的拷贝
+func (c *ValueCache) Get(pos *LogRecordPos) ([]byte, bool) {
+	key := valueKey{fid: pos.Fid, offset: pos.Offset}
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	elem, ok := shard.values[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	shard.lru.MoveToFront(elem)
+	value := elem.Value.(*valueEntry).value
+	return append([]byte(nil), value...), true
+}
+
+// Put 缓存位置 pos 上的 value，超出容量时淘汰最久没有访问的数据
+func (c *ValueCache) Put(pos *LogRecordPos, value []byte) {
+	key := valueKey{fid: pos.Fid, offset: pos.Offset}
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entrySize := int64(len(value)) + valueEntryOverhead
+	if entrySize > shard.capacity {
+		return
+	}
+	if _, ok := shard.values[key]; ok {
+		return
+	}
+	entry := &valueEntry{key: key, value: append([]byte(nil), value...)}
+	shard.values[key] = shard.lru.PushFront(entry)
+	shard.size += entrySize
+
+	for shard.size > shard.capacity {
+		elem := shard.lru.Back()
+		old := elem.Value.(*valueEntry)
+		shard.lru.Remove(elem)
+		delete(shard.values, old.key)
+		shard.size -= int64(len(old.value)) + valueEntryOverhead
+	}
+}
+
+// Stats 返回缓存的统计信息
+func (c *ValueCache) Stats() ValueCacheStats {
+	var size int64
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		size += shard.size
+		shard.mu.Unlock()
+	}
+	return ValueCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+}
+
+func (c *ValueCache) shard(key valueKey) *valueCacheShard {
+	h := uint64(key.fid)*31 + uint64(key.offset)
+	return c.shards[h%valueCacheShards]
+}
+
+// Clear 清空缓存中的所有数据
+func (c *ValueCache) Clear() {
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		shard.lru.Init()
+		shard.values = make(map[valueKey]*list.Element)
+		shard.size = 0
+		shard.mu.Unlock()
+	}
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValueCache(t *testing.T) {
+	cache := NewValueCache(valueCacheShards * (100 + valueEntryOverhead) * 2)
+
+	pos := &LogRecordPos{Fid: 1, Offset: 8}
+	_, ok := cache.Get(pos)
+	assert.False(t, ok)
+	cache.Put(pos, []byte("bitcask-go"))
+	val, ok := cache.Get(pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bitcask-go"), val)
+
+	// 修改返回的数据不影响缓存
+	val[0] = 'B'
+	val, ok = cache.Get(pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bitcask-go"), val)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, int64(len("bitcask-go")+valueEntryOverhead), stats.Size)
+
+	// 超出容量时淘汰最久没有访问的数据
+	key := valueKey{fid: pos.Fid, offset: pos.Offset}
+	shard := cache.shard(key)
+	var others []*LogRecordPos
+	for offset := int64(100); len(others) < 2; offset++ {
+		p := &LogRecordPos{Fid: 1, Offset: offset}
+		if cache.shard(valueKey{fid: p.Fid, offset: p.Offset}) == shard {
+			others = append(others, p)
+		}
+	}
+	cache.Put(others[0], make([]byte, 100))
+	_, ok = cache.Get(pos)
+	assert.True(t, ok)
+	cache.Put(others[1], make([]byte, 100))
+
+	_, ok = cache.Get(others[0])
+	assert.False(t, ok)
+	_, ok = cache.Get(pos)
+	assert.True(t, ok)
+	_, ok = cache.Get(others[1])
+	assert.True(t, ok)
+
+	// 超过分片容量的数据不缓存
+	big := &LogRecordPos{Fid: 2, Offset: 8}
+	cache.Put(big, make([]byte, 1024))
+	_, ok = cache.Get(big)
+	assert.False(t, ok)
+
+	cache.Clear()
+	_, ok = cache.Get(pos)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), cache.Stats().Size)
+}
@@ -2,8 +2,12 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/index"
+	"bitcask-go/utils"
 	"errors"
+	"fmt"
+	"github.com/gofrs/flock"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,6 +15,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DB struct {
@@ -24,9 +30,55 @@ type DB struct {
 	isMerging       bool                      //是否有文件在merge
 	seqNoFileExists bool                      //是否已经存在seqnofile
 	isInitial       bool                      //是否是第一次初始化此目录
+	fileLock        *flock.Flock              //文件锁，保证多进程之间的互斥
+	reclaimSize     int64                     //表示有多少数据是无效的，可以被merge回收
+	fileGarbage     map[uint32]int64          //每个数据文件中可以被 merge 回收的数据量
+	snapshots       map[*Snapshot]struct{}    //当前活跃的快照
+	iterators       map[*Iterator]struct{}    //还没有关闭的迭代器
+	mergeEpoch      uint64                    //在线安装 merge 结果的次数，快照和迭代器据此读取被替换的旧文件
+	retiredFiles    []*retiredFiles           //被 merge 替换但还可能被快照和迭代器读取的旧数据文件
+	mergeInstallMu  *sync.RWMutex             //在线安装 merge 结果时和备份互斥
+	recovery        *RecoveryReport           //启动时截断活跃文件的信息
+	bytesWrite      uint                      //累计写了多少字节还没有持久化
+	syncClose       chan struct{}             //通知定时持久化的协程退出
+	syncWg          sync.WaitGroup            //等待定时持久化的协程退出
+	mergeClose      chan struct{}             //通知自动 merge 的协程退出
+	mergeWg         sync.WaitGroup            //等待自动 merge 的协程退出
+	commitMu        *sync.Mutex               //保护组提交的等待队列
+	commitQueue     []*commitRequest          //等待组提交的写入请求
+	committing      bool                      //是否已经有组提交的 leader 在写入
+	fs              fio.FS                    //数据目录所在的文件系统
+	blockCache      *data.BlockCache          //数据文件的数据块缓存
+	valueCache      *data.ValueCache          //用户数据缓存
 }
 
-const seqNoKey = "seq.no"
+// Stat 存储引擎统计信息
+type Stat struct {
+	KeyNum          uint   // key 的总数量
+	DataFileNum     uint   // 数据文件的数量
+	ReclaimableSize int64  // 可以进行 merge 回收的数据量，字节为单位
+	DiskSize        int64  // 数据目录所占磁盘空间大小
+	UnsyncedSize    int64  // 已经写入但还没有持久化的数据量，字节为单位
+	BlockCacheHits  uint64 // 数据块缓存命中的次数
+	BlockCacheMiss  uint64 // 数据块缓存没有命中的次数
+	BlockCacheSize  int64  // 数据块缓存当前的大小
+	ValueCacheHits  uint64 // 用户数据缓存命中的次数
+	ValueCacheMiss  uint64 // 用户数据缓存没有命中的次数
+	ValueCacheSize  int64  // 用户数据缓存当前的大小
+}
+
+// RecoveryReport 启动时活跃文件末尾不完整数据的处理结果
+type RecoveryReport struct {
+	FileId         uint32 // 被截断的数据文件 id
+	Offset         int64  // 截断的位置，即最后一条完整数据的结束位置
+	TruncatedBytes int64  // 被截断的字节数
+	Cause          error  // 截断的原因，data.ErrInvalidCRC 或者 io.ErrUnexpectedEOF
+}
+
+const (
+	seqNoKey     = "seq.no"
+	fileLockName = "flock"
+)
 
 // Open
 //
@@ -39,68 +91,201 @@ func Open(options Options) (*DB, error) {
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	fs := options.FS
+	if fs == nil {
+		fs = fio.OSFS
+		if options.InMemory {
+			fs = fio.NewMemFS()
+		}
+	}
 	var isInitial bool
 	//判断数据目录是否存在，不存在则创建目录
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
-		if err = os.Mkdir(options.DirPath, os.ModePerm); err != nil {
+	if _, err := fs.Stat(options.DirPath); os.IsNotExist(err) {
+		// 只读模式下不创建目录
+		if options.ReadOnly {
+			return nil, err
+		}
+		if err = fs.MkdirAll(options.DirPath); err != nil {
 			return nil, err
 		}
 	}
 
-	entries, err := os.ReadDir(options.DirPath)
+	// 判断当前数据目录是否正在使用，只读模式下多个实例可以共享，但是正在运行的读写实例会让只读模式打开失败
+	// 文件锁只对操作系统的文件系统有效
+	var fileLock *flock.Flock
+	lockFileName := filepath.Join(options.DirPath, fileLockName)
+	useLock := fs == fio.OSFS
+	if useLock && options.ReadOnly {
+		// 加锁时会创建文件锁，只读模式下不能修改目录，文件锁不存在说明没有读写实例打开过这个目录
+		if _, err := fs.Stat(lockFileName); os.IsNotExist(err) {
+			useLock = false
+		}
+	}
+	if useLock {
+		fileLock = flock.New(lockFileName)
+		var hold bool
+		var err error
+		if options.ReadOnly {
+			hold, err = fileLock.TryRLock()
+		} else {
+			hold, err = fileLock.TryLock()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+	}
+	unlock := func() {
+		if fileLock != nil {
+			_ = fileLock.Unlock()
+		}
+	}
+
+	entries, err := fs.ReadDir(options.DirPath)
 	if err != nil {
+		unlock()
 		return nil, err
 	}
-	if len(entries) == 0 {
+	//目录中只有文件锁，说明是第一次初始化
+	if len(entries) == 0 || (len(entries) == 1 && entries[0].Name() == fileLockName) {
 		isInitial = true
 	}
 	//初始化Db实例结构体
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
+		options:        options,
+		mu:             new(sync.RWMutex),
+		olderFiles:     make(map[uint32]*data.DataFile),
+		snapshots:      make(map[*Snapshot]struct{}),
+		iterators:      make(map[*Iterator]struct{}),
+		fileGarbage:    make(map[uint32]int64),
+		isInitial:      isInitial,
+		fileLock:       fileLock,
+		commitMu:       new(sync.Mutex),
+		mergeInstallMu: new(sync.RWMutex),
+		fs:             fs,
+	}
+	if options.BlockCacheSize > 0 {
+		db.blockCache = data.NewBlockCache(options.BlockCacheSize)
+	}
+	if options.ValueCacheSize > 0 {
+		db.valueCache = data.NewValueCache(options.ValueCacheSize)
 	}
 
-	// 加载merge 数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	if err := db.load(); err != nil {
+		unlock()
 		return nil, err
 	}
+
+	// 开启定时持久化
+	if options.SyncInterval > 0 && !options.ReadOnly {
+		db.syncClose = make(chan struct{})
+		db.syncWg.Add(1)
+		go db.syncPeriodically()
+	}
+	if options.MergeCheckInterval > 0 && !options.ReadOnly {
+		db.mergeClose = make(chan struct{})
+		db.mergeWg.Add(1)
+		go db.mergePeriodically()
+	}
+	return db, nil
+}
+
+// load 加载数据文件和索引，失败时由调用方释放文件锁
+func (db *DB) load() error {
+	options := db.options
+	db.index = index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites, options.ReadOnly)
+
+	// 加载merge 数据目录，只读模式下不处理
+	if !options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return err
+		}
+	}
 	// 加载数据文件
 	if err := db.loadDataFile(); err != nil {
-		return nil, err
+		return err
 	}
 
 	if options.IndexType != BPlusTree {
 		// 从hint索引文件加载索引
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
 
 		//  从数据文件中加载索引
 		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+			return err
 		}
 	} else {
 		//取出当前事务序列号
 		if err := db.loadSeqNO(); err != nil {
-			return nil, err
+			return err
 		}
 		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
+			if err := db.loadActiveFileEnd(); err != nil {
+				return err
+			}
+		}
+		//旧的数据文件不会再写入，文件大小就是数据的末尾
+		for _, dataFile := range db.olderFiles {
+			size, err := dataFile.IoManager.Size()
 			if err != nil {
-				return nil, err
+				return err
 			}
-			db.activeFile.WriteOff = size
+			dataFile.WriteOff = size
+		}
+		//恢复每个数据文件中可回收的数据量
+		if err := db.loadGarbage(); err != nil {
+			return err
 		}
 	}
 
-	return db, nil
+	// 索引加载完成，重置为标准文件 IO
+	if options.MMapAtStartup {
+		if err := db.resetIoType(); err != nil {
+			return err
+		}
+	}
+
+	// 截掉上次崩溃时留下的预分配空间，需要时重新分配
+	if db.activeFile != nil && !options.ReadOnly {
+		if err := db.activeFile.Trim(); err != nil {
+			return err
+		}
+		if options.PreallocateDataFile {
+			if err := db.activeFile.Preallocate(options.DataFileSize); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // 关闭数据库
-func (db *DB) Close() error {
+func (db *DB) Close() (err error) {
+	defer func() {
+		//释放文件锁，关闭过程中已经出错时优先返回之前的错误
+		if db.fileLock == nil {
+			return
+		}
+		if unlockErr := db.fileLock.Unlock(); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}()
+	//停止定时持久化，必须在获取锁之前，否则会和协程互相等待
+	if db.syncClose != nil {
+		close(db.syncClose)
+		db.syncWg.Wait()
+		db.syncClose = nil
+	}
+	//停止自动 merge，正在进行的 merge 会被取消
+	if db.mergeClose != nil {
+		close(db.mergeClose)
+		db.mergeWg.Wait()
+		db.mergeClose = nil
+	}
 	if db.activeFile == nil {
 		return nil
 	}
@@ -112,20 +297,22 @@ func (db *DB) Close() error {
 		return err
 	}
 	//保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+	if !db.options.ReadOnly {
+		if err := writeSeqNoFile(db.fs, db.options.DirPath, db.seqNo); err != nil {
+			return err
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
-
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
+	//B+ 树索引重启时不加载数据文件，保存每个数据文件中可回收的数据量
+	if !db.options.ReadOnly && db.options.IndexType == BPlusTree {
+		if err := db.writeGarbageFile(); err != nil {
+			return err
+		}
 	}
-	if err := seqNoFile.Sync(); err != nil {
+	//截掉活跃文件预分配的空间
+	if !db.options.ReadOnly {
+		if err := db.activeFile.Trim(); err != nil {
+			return err
+		}
 	}
 	//关闭当前活跃文件
 	if err := db.activeFile.Close(); err != nil {
@@ -137,9 +324,52 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+	//关闭被 merge 替换的旧数据文件
+	for _, retired := range db.retiredFiles {
+		for _, file := range retired.files {
+			_ = file.Close()
+		}
+	}
+	db.retiredFiles = nil
 	return nil
 }
 
+// Stat 返回数据库的相关统计信息
+func (db *DB) Stat() (*Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var dataFiles = uint(len(db.olderFiles))
+	if db.activeFile != nil {
+		dataFiles += 1
+	}
+
+	dirSize, err := utils.DirSize(db.fs, db.options.DirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dir size: %w", err)
+	}
+	stat := &Stat{
+		KeyNum:          uint(db.index.Size()),
+		DataFileNum:     dataFiles,
+		ReclaimableSize: atomic.LoadInt64(&db.reclaimSize),
+		DiskSize:        dirSize,
+		UnsyncedSize:    int64(db.bytesWrite),
+	}
+	if db.blockCache != nil {
+		cacheStats := db.blockCache.Stats()
+		stat.BlockCacheHits = cacheStats.Hits
+		stat.BlockCacheMiss = cacheStats.Misses
+		stat.BlockCacheSize = cacheStats.Size
+	}
+	if db.valueCache != nil {
+		cacheStats := db.valueCache.Stats()
+		stat.ValueCacheHits = cacheStats.Hits
+		stat.ValueCacheMiss = cacheStats.Misses
+		stat.ValueCacheSize = cacheStats.Size
+	}
+	return stat, nil
+}
+
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
 	if db.activeFile == nil {
@@ -147,33 +377,115 @@ func (db *DB) Sync() error {
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFile()
+}
+
+// syncActiveFile 持久化活跃文件并清空未持久化的字节数，在访问此方法之前必须持有互斥锁
+func (db *DB) syncActiveFile() error {
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	return nil
+}
+
+// syncPeriodically 按照 SyncInterval 定时持久化活跃文件
+func (db *DB) syncPeriodically() {
+	defer db.syncWg.Done()
+	ticker := time.NewTicker(db.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			// 持久化失败时保留未持久化的字节数，下次继续尝试
+			if db.activeFile != nil && db.bytesWrite > 0 {
+				_ = db.syncActiveFile()
+			}
+			db.mu.Unlock()
+		case <-db.syncClose:
+			return
+		}
+	}
 }
 
 // Put 写入key、value
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.putWithExpire(key, value, 0)
+}
+
+// PutWithTTL 写入key、value，并设置过期时间，ttl 小于等于 0 表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return db.putWithExpire(key, value, expire)
+}
+
+// TTL 获取key剩余的存活时间，永不过期的key返回 0
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	logRecordPos := db.index.Get(key)
+	now := time.Now().UnixNano()
+	if logRecordPos == nil || logRecordPos.IsExpired(now) {
+		return 0, ErrKeyNotFound
+	}
+	if logRecordPos.Expire == 0 {
+		return 0, nil
+	}
+	return time.Duration(logRecordPos.Expire - now), nil
+}
+
+func (db *DB) putWithExpire(key []byte, value []byte, expire int64) error {
 	// key是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// 每次写入都需要持久化时，合并并发的写入，多条数据只需要一次持久化
+	if db.options.SyncWrites {
+		return db.groupCommit(&commitRequest{
+			key: key,
+			record: &data.LogRecord{
+				Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+				Value:  value,
+				Type:   data.LogRecordNormal,
+				Expire: expire,
+			},
+		})
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.put(key, value, expire)
+}
 
+// put 写入数据并更新内存索引，在访问此方法之前必须持有互斥锁
+func (db *DB) put(key []byte, value []byte, expire int64) error {
 	// 构造LogRecord结构体
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
 
 	// 追加写入数据到当前活跃数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
-		return ErrIndexUpdateFailed
-	}
+	db.updateIndex(key, data.LogRecordNormal, pos)
 	return nil
 }
 
@@ -183,25 +495,35 @@ func (db *DB) Delete(key []byte) error {
 		return ErrKeyIsEmpty
 	}
 
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	// 检查key是否存在，如果不存在直接返回
 	if pos := db.index.Get(key); pos == nil {
 		return nil
 	}
+	return db.delete(key)
+}
 
+// delete 写入删除标记并更新内存索引，在访问此方法之前必须持有互斥锁
+func (db *DB) delete(key []byte) error {
 	//	构造 LogRecord，标识是被删除的key
 	logRecord := &data.LogRecord{
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
 	// 写入到数据文件中
-	_, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	//从内存索引中将对应的Key删除
-	ok := db.index.Delete(key)
-	if !ok {
+	if _, ok := db.updateIndex(key, data.LogRecordDeleted, pos); !ok {
 		return ErrIndexUpdateFailed
 	}
 	return nil
@@ -213,23 +535,31 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	return db.get(key)
+}
 
+// get 读取key对应的value，在访问此方法之前必须持有锁
+func (db *DB) get(key []byte) ([]byte, error) {
 	//  从内存数据结构中取出key对应得索引信息
 	logRecordPos := db.index.Get(key)
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
 	}
 
-	return db.getValueByPosition(logRecordPos)
+	return db.getValueByPosition(logRecordPos, db.mergeEpoch)
 }
 
-// ListKeys 获取数据库所有的key
+// ListKeys 获取数据库所有的key，已过期的key不会返回
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	defer iterator.Close()
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -242,7 +572,11 @@ func (db *DB) Fold(fn func(k []byte, v []byte) bool) error {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
+		value, err := db.getValueByPosition(iterator.Value(), db.mergeEpoch)
+		if err == ErrKeyNotFound {
+			//跳过已经过期的数据
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -253,17 +587,25 @@ func (db *DB) Fold(fn func(k []byte, v []byte) bool) error {
 	return nil
 }
 
-func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
+// getValueByPosition 根据位置信息读取 value，epoch 是读取者创建时的 merge 版本，在访问此方法之前必须持有锁
+func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos, epoch uint64) ([]byte, error) {
+	// 已经过期的数据视为不存在
+	if logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
 	}
 
+	dataFile, retired := db.getDataFile(logRecordPos.Fid, epoch)
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
+	// 数据的位置不会变化，缓存中有的话直接返回，已经被 merge 替换的旧文件不使用缓存
+	useCache := db.valueCache != nil && !retired
+	if useCache {
+		if value, ok := db.valueCache.Get(logRecordPos); ok {
+			return value, nil
+		}
+	}
+
 	//  根据偏移读取对应得数据
 	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
 	if err != nil {
@@ -273,40 +615,65 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrKeyNotFound
 	}
+	if useCache {
+		db.valueCache.Put(logRecordPos, logRecord.Value)
+	}
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// getDataFile 获取 epoch 版本时 id 对应的数据文件，已经被 merge 替换的旧文件返回 true，在访问此方法之前必须持有锁
+func (db *DB) getDataFile(fid uint32, epoch uint64) (*data.DataFile, bool) {
+	for _, retired := range db.retiredFiles {
+		if retired.epoch <= epoch {
+			continue
+		}
+		if dataFile, ok := retired.files[fid]; ok {
+			return dataFile, true
+		}
+	}
+	if db.activeFile.FileId == fid {
+		return db.activeFile, false
+	}
+	return db.olderFiles[fid], false
+}
+
+// updateIndex 更新内存索引，并统计可以被回收的数据量，在访问此方法之前必须持有互斥锁
+func (db *DB) updateIndex(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) (*data.LogRecordPos, bool) {
+	var oldPos *data.LogRecordPos
+	var ok = true
+	if typ == data.LogRecordDeleted {
+		oldPos, ok = db.index.Delete(key)
+		// 删除标记本身也是可以被回收的数据
+		db.addGarbage(pos.Fid, int64(pos.Size))
+	} else {
+		oldPos = db.index.Put(key, pos)
+	}
+	if oldPos != nil {
+		db.addGarbage(oldPos.Fid, int64(oldPos.Size))
+	}
+	// 活跃的快照需要保留key被修改之前的位置
+	for snap := range db.snapshots {
+		snap.saveOldPos(key, oldPos)
+	}
+	return oldPos, ok
+}
+
+// addGarbage 统计数据文件中可以被回收的数据量，在访问此方法之前必须持有互斥锁
+func (db *DB) addGarbage(fid uint32, size int64) {
+	db.fileGarbage[fid] += size
+	atomic.AddInt64(&db.reclaimSize, size)
 }
 
 // 追加写到活跃文件中
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-
-	//判断当前活跃数据文件是否存在，因为数据库在没有写入的时候是没有文件生成的  如果为空则初始化数据文件
-	if db.activeFile == nil {
-		if err := db.setActiveDataFile(); err != nil {
-			return nil, err
-		}
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
 	}
 
 	//写入数据编码
 	encRecord, size := data.EncodeLogRecord(logRecord)
-	//如果写入的数据已经达到了活跃文件的阈值，关闭活跃文件，并打开新的
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		//持久化数据，保证已有的数据持久到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-		//当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		//打开新的数据文件
-		if err := db.setActiveDataFile(); err != nil {
-			return nil, err
-		}
+	if err := db.prepareActiveFile(size); err != nil {
+		return nil, err
 	}
 
 	writeOff := db.activeFile.WriteOff
@@ -315,38 +682,154 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	//根据用户配置决定是否持久化
-	if db.options.SyncWrites {
-		if err := db.activeFile.Sync(); err != nil {
+	db.bytesWrite += uint(size)
+	var needSync = db.options.SyncWrites
+	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
+		needSync = true
+	}
+	if needSync {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 	}
 
 	//内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff}
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size), Expire: logRecord.Expire}
 	return pos, nil
 
 }
 
+// prepareActiveFile 保证活跃文件能够写入 size 大小的数据，在访问此方法之前必须持有互斥锁
+func (db *DB) prepareActiveFile(size int64) error {
+	//判断当前活跃数据文件是否存在，因为数据库在没有写入的时候是没有文件生成的  如果为空则初始化数据文件
+	if db.activeFile == nil {
+		return db.setActiveDataFile()
+	}
+
+	//如果写入的数据已经达到了活跃文件的阈值，关闭活跃文件，并打开新的
+	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+		return db.rotateActiveFile()
+	}
+	return nil
+}
+
+// rotateActiveFile 将活跃文件转换为旧的数据文件，并打开新的活跃文件，在访问此方法之前必须持有互斥锁
+func (db *DB) rotateActiveFile() error {
+	return db.rotateActiveFileTo(db.activeFile.FileId + 1)
+}
+
+// rotateActiveFileTo 将活跃文件转换为旧的数据文件，并打开 id 为 fileId 的新活跃文件，在访问此方法之前必须持有互斥锁
+func (db *DB) rotateActiveFileTo(fileId uint32) error {
+	//截掉预分配的空间，旧的数据文件不会再写入
+	if err := db.activeFile.Trim(); err != nil {
+		return err
+	}
+	//持久化数据，保证已有的数据持久到磁盘当中
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+	//当前活跃文件转换为旧的数据文件
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+
+	//打开新的数据文件
+	return db.openActiveDataFile(fileId)
+}
+
 // 设置当前活跃文件 在访问此方法之前必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
 	var initialFileId uint32 = 0
 	if db.activeFile != nil {
 		initialFileId = db.activeFile.FileId + 1
 	}
+	return db.openActiveDataFile(initialFileId)
+}
 
+// openActiveDataFile 打开 id 为 fileId 的数据文件作为活跃文件，在访问此方法之前必须持有互斥锁
+func (db *DB) openActiveDataFile(fileId uint32) error {
 	//打开数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId)
+	dataFile, err := db.openDataFile(fileId, db.dataFileIOType())
 	if err != nil {
 		return err
 	}
+	//预分配数据文件的空间，写入时不再改变文件大小
+	if db.options.PreallocateDataFile {
+		if err := dataFile.Preallocate(db.options.DataFileSize); err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+	}
 	db.activeFile = dataFile
 	return nil
 }
 
+// resetIoType 将启动时使用 MMap 打开的数据文件重新以标准文件 IO 打开
+func (db *DB) resetIoType() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	reopen := func(dataFile *data.DataFile) (*data.DataFile, error) {
+		if err := dataFile.Close(); err != nil {
+			return nil, err
+		}
+		newFile, err := db.openDataFile(dataFile.FileId, db.dataFileIOType())
+		if err != nil {
+			return nil, err
+		}
+		// 保留加载索引时得到的 WriteOff，空文件重新打开时会写入文件头部
+		if dataFile.WriteOff > newFile.WriteOff {
+			newFile.WriteOff = dataFile.WriteOff
+		}
+		return newFile, nil
+	}
+
+	activeFile, err := reopen(db.activeFile)
+	if err != nil {
+		return err
+	}
+	db.activeFile = activeFile
+
+	for fid, dataFile := range db.olderFiles {
+		olderFile, err := reopen(dataFile)
+		if err != nil {
+			return err
+		}
+		db.olderFiles[fid] = olderFile
+	}
+	return nil
+}
+
+// openDataFile 打开数据文件，并设置数据块缓存
+func (db *DB) openDataFile(fileId uint32, ioType fio.FileIOType) (*data.DataFile, error) {
+	dataFile, err := data.OpenDataFile(db.fs, db.options.DirPath, fileId, ioType)
+	if err != nil {
+		return nil, err
+	}
+	if db.blockCache != nil {
+		dataFile.SetBlockCache(db.blockCache)
+	}
+	return dataFile, nil
+}
+
+// dataFileIOType 数据文件使用的IO类型
+func (db *DB) dataFileIOType() fio.FileIOType {
+	if db.options.DirectIO && !db.options.ReadOnly {
+		return fio.DirectFIO
+	}
+	return db.fileIOType()
+}
+
+// fileIOType 加载已有文件时使用的IO类型
+func (db *DB) fileIOType() fio.FileIOType {
+	if db.options.ReadOnly {
+		return fio.ReadOnlyFIO
+	}
+	return fio.StandardFIO
+}
+
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFile() error {
 
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	dirEntries, err := db.fs.ReadDir(db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -368,9 +851,12 @@ func (db *DB) loadDataFile() error {
 	sort.Ints(fileIds)
 	db.fileIds = fileIds
 	//  遍历每个文件id，打开对应的数据文件
-
+	ioType := db.dataFileIOType()
+	if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
+	}
 	for i, fid := range fileIds {
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid))
+		dataFile, err := db.openDataFile(uint32(fid), ioType)
 		if err != nil {
 			return err
 		}
@@ -399,7 +885,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 	//查看是否发生过merge
 	hasMerge, nonMergeFileID := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
 		fid, err := db.getNonMergeFileID(db.options.DirPath)
 		if err != nil {
 			return err
@@ -408,59 +894,74 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileID = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var ok bool
-		if typ == data.LogRecordDeleted {
-			ok = db.index.Delete(key)
-		} else {
-			ok = db.index.Put(key, pos)
-		}
-		if !ok {
-			panic("failed to update index")
-		}
-	}
-
 	//暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo uint64 = nonTransactionSeqNo
 	//遍历所有文件id，处理文件中的记录
 	for i, fid := range db.fileIds {
 		var fileId = uint32(fid)
-		//如果比最近未参与merge的文件id更小，则说明已经从hint中加载过了
-		if hasMerge && fileId < nonMergeFileID {
-			continue
-		}
 		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileId {
 			dataFile = db.activeFile
 		} else {
 			dataFile = db.olderFiles[fileId]
 		}
+		//如果比最近未参与merge的文件id更小，则说明已经从hint中加载过了
+		if hasMerge && fileId < nonMergeFileID {
+			size, err := dataFile.IoManager.Size()
+			if err != nil {
+				return err
+			}
+			dataFile.WriteOff = size
+			continue
+		}
+		//增量 merge 生成的文件有对应的 hint 文件，直接从中加载索引
+		loaded, err := db.loadIndexFromDataHintFile(dataFile)
+		if err != nil {
+			return err
+		}
+		if loaded {
+			continue
+		}
 
-		var offset int64 = 0
+		scanner, err := data.NewRecordScanner(dataFile, dataFile.HeaderSize)
+		if err != nil {
+			return err
+		}
 
 		//循环处理文件中的内容
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			logRecord, offset, size, err := scanner.Next()
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
+				// 活跃文件末尾的数据可能因为崩溃没有写完整，截断之后继续加载
+				if i == len(db.fileIds)-1 {
+					truncated, terr := db.truncateTornTail(offset, size, err)
+					if terr != nil {
+						return terr
+					}
+					if truncated {
+						break
+					}
+				}
 				return err
 			}
 
 			//构造内存索引
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset}
+			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
 
 			//解析 Key，拿到事务序列号
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
 			if seqNo == nonTransactionSeqNo {
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				db.updateIndex(realKey, logRecord.Type, logRecordPos)
 			} else {
 				//事务完成，对应得seq no数据更新到内存索引当中
 				if logRecord.Type == data.LogRecordTxnFinished {
+					db.addGarbage(fileId, size)
 					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+						db.updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
 					}
 					delete(transactionRecords, seqNo)
 				} else {
@@ -476,20 +977,81 @@ func (db *DB) loadIndexFromDataFiles() error {
 			if seqNo > currentSeqNo {
 				currentSeqNo = seqNo
 			}
-			//递增offset,下一次从新的位置读取
-			offset += size
 		}
 
-		//如果当前是活跃文件，记录文件的WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
-		}
+		//记录文件的WriteOff，即数据的末尾
+		dataFile.WriteOff = scanner.Offset()
 	}
 	//更新事务序列号
 	db.seqNo = currentSeqNo
 	return nil
 }
 
+// loadActiveFileEnd B+ 树索引不需要从数据文件中加载，但是仍然要找到活跃文件中数据真正的末尾
+// 末尾可能是预分配的空间或者崩溃时没有写完整的数据，和加载索引时一样处理，文件中间被损坏时返回错误
+func (db *DB) loadActiveFileEnd() error {
+	scanner, err := data.NewRecordScanner(db.activeFile, db.activeFile.HeaderSize)
+	if err != nil {
+		return err
+	}
+	for {
+		_, offset, size, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			truncated, terr := db.truncateTornTail(offset, size, err)
+			if terr != nil {
+				return terr
+			}
+			if truncated {
+				break
+			}
+			return err
+		}
+	}
+	db.activeFile.WriteOff = scanner.Offset()
+	return nil
+}
+
+// truncateTornTail 如果活跃文件中offset处是最后一条不完整的数据，将文件截断到offset
+// 只读模式下不修改文件，只忽略这部分数据
+func (db *DB) truncateTornTail(offset, size int64, cause error) (bool, error) {
+	fileSize, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	// 校验失败的数据之后还有其他数据，说明文件中间被损坏了，预分配的空间除外
+	if cause == data.ErrInvalidCRC && offset+size < fileSize {
+		zero, err := db.activeFile.IsZeroFrom(offset + size)
+		if err != nil || !zero {
+			return false, err
+		}
+	}
+	if cause != data.ErrInvalidCRC && cause != io.ErrUnexpectedEOF {
+		return false, nil
+	}
+
+	db.recovery = &RecoveryReport{
+		FileId:         db.activeFile.FileId,
+		Offset:         offset,
+		TruncatedBytes: fileSize - offset,
+		Cause:          cause,
+	}
+	if db.options.ReadOnly {
+		return true, nil
+	}
+	if err := db.activeFile.IoManager.Truncate(offset); err != nil {
+		return false, err
+	}
+	return true, db.activeFile.Sync()
+}
+
+// RecoveryReport 返回启动时的恢复信息，没有截断任何数据时返回 nil
+func (db *DB) RecoveryReport() *RecoveryReport {
+	return db.recovery
+}
+
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
 		return errors.New("DirPath is empty")
@@ -497,19 +1059,41 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("DataFileSize must be greater than 0")
 	}
+	if options.SyncInterval < 0 {
+		return errors.New("SyncInterval must not be negative")
+	}
+	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
+		return errors.New("invalid merge ratio, must between 0 and 1")
+	}
+	if options.MergeFreeSpaceMargin < 0 {
+		return errors.New("MergeFreeSpaceMargin must not be negative")
+	}
+	if options.IncrementalMergeFiles < 0 {
+		return errors.New("IncrementalMergeFiles must not be negative")
+	}
+	if options.MergeCheckInterval < 0 {
+		return errors.New("MergeCheckInterval must not be negative")
+	}
+	if options.IndexType == BPlusTree && (options.InMemory || (options.FS != nil && options.FS != fio.OSFS)) {
+		return errors.New("B+ tree index only supports the os file system")
+	}
 	return nil
 }
 
 func (db *DB) loadSeqNO() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
 		return nil
 	}
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.fs, db.options.DirPath, db.fileIOType())
+	if err != nil {
+		return err
+	}
+	defer seqNoFile.Close()
+	record, _, err := seqNoFile.ReadLogRecord(seqNoFile.HeaderSize)
 	if err != nil {
 		return err
 	}
-	record, _, err := seqNoFile.ReadLogRecord(0)
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
@@ -519,3 +1103,149 @@ func (db *DB) loadSeqNO() error {
 
 	return nil
 }
+
+// writeGarbageFile 保存每个数据文件中可回收的数据量，在访问此方法之前必须持有互斥锁
+func (db *DB) writeGarbageFile() error {
+	var records []*data.LogRecord
+	for fid, garbage := range db.fileGarbage {
+		if garbage <= 0 {
+			continue
+		}
+		records = append(records, &data.LogRecord{
+			Key:   []byte(strconv.FormatUint(uint64(fid), 10)),
+			Value: []byte(strconv.FormatInt(garbage, 10)),
+		})
+	}
+	return replaceFile(db.fs, db.options.DirPath, data.GarbageFileName, data.GarbageTempFileName, data.OpenGarbageTempFile, records)
+}
+
+// loadGarbage B+ 树索引启动时恢复每个数据文件中可回收的数据量
+// 正常关闭时保存了统计信息，直接读取，读取之后删除文件，避免崩溃之后使用过期的统计信息
+// 文件不存在时说明上次没有正常关闭，遍历数据文件和索引重新统计
+func (db *DB) loadGarbage() error {
+	fileName := filepath.Join(db.options.DirPath, data.GarbageFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return db.rebuildGarbage()
+	}
+	garbageFile, err := data.OpenGarbageFile(db.fs, db.options.DirPath, db.fileIOType())
+	if err != nil {
+		return err
+	}
+	defer garbageFile.Close()
+
+	scanner, err := data.NewRecordScanner(garbageFile, garbageFile.HeaderSize)
+	if err != nil {
+		return err
+	}
+	for {
+		record, _, _, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fid, err := strconv.ParseUint(string(record.Key), 10, 32)
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		garbage, err := strconv.ParseInt(string(record.Value), 10, 64)
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		if db.getDataFileById(uint32(fid)) != nil {
+			db.addGarbage(uint32(fid), garbage)
+		}
+	}
+	if db.options.ReadOnly {
+		return nil
+	}
+	return db.fs.Remove(fileName)
+}
+
+// rebuildGarbage 遍历所有的数据文件，和索引中的位置比较，统计每个文件中可回收的数据量
+func (db *DB) rebuildGarbage() error {
+	fileIds := make([]uint32, 0, len(db.olderFiles)+1)
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	if db.activeFile != nil {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	for _, fid := range fileIds {
+		dataFile := db.getDataFileById(fid)
+		scanner, err := data.NewRecordScanner(dataFile, dataFile.HeaderSize)
+		if err != nil {
+			return err
+		}
+		for scanner.Offset() < dataFile.WriteOff {
+			logRecord, offset, size, err := scanner.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			// 删除标记和事务完成的标记都是无效数据，其他数据只有索引指向它时才有效
+			if logRecord.Type == data.LogRecordNormal {
+				realKey, _ := parseLogRecordKey(logRecord.Key)
+				pos := db.index.Get(realKey)
+				if pos != nil && pos.Fid == fid && pos.Offset == offset {
+					continue
+				}
+			}
+			db.addGarbage(fid, size)
+		}
+	}
+	return nil
+}
+
+// getDataFileById 根据 id 获取活跃文件或者旧的数据文件，在访问此方法之前必须持有互斥锁
+func (db *DB) getDataFileById(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.olderFiles[fid]
+}
+
+// writeSeqNoFile 将事务序列号写到指定目录的seqnofile中，已有的文件会被覆盖
+func writeSeqNoFile(fs fio.FS, dirPath string, seqNo uint64) error {
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
+	}
+	return replaceFile(fs, dirPath, data.SeqNoFileName, data.SeqNoTempFileName, data.OpenSeqNoTempFile, []*data.LogRecord{record})
+}
+
+// replaceFile 将 records 写到指定目录中的 fileName 文件，已有的文件会被覆盖
+// 先写到临时文件并持久化，再重命名覆盖原来的文件，中途崩溃时原来的文件仍然完整
+func replaceFile(fs fio.FS, dirPath, fileName, tempFileName string,
+	openTempFile func(fio.FS, string, fio.FileIOType) (*data.DataFile, error), records []*data.LogRecord) error {
+	tempFilePath := filepath.Join(dirPath, tempFileName)
+	// 上次没有写完的临时文件
+	if err := fs.Remove(tempFilePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tempFile, err := openTempFile(fs, dirPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, record := range records {
+		encRecord, _ := data.EncodeLogRecord(record)
+		buf = append(buf, encRecord...)
+	}
+	if err := tempFile.Write(buf); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tempFilePath, filepath.Join(dirPath, fileName))
+}
@@ -1,10 +1,15 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 // 测试完成之后销毁 DB 数据目录
@@ -20,6 +25,13 @@ func destroyDB(db *DB) {
 	}
 }
 
+// getStat 获取统计信息，出错时测试失败
+func getStat(t *testing.T, db *DB) *Stat {
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	return stat
+}
+
 func TestOpen(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go")
@@ -73,8 +85,7 @@ func TestDB_Put(t *testing.T) {
 	assert.Equal(t, 2, len(db.olderFiles))
 
 	// 6.重启后再 Put 数据
-	db.Close() // todo 实现 Close 方法后这里用 Close() 替代
-	err = db.activeFile.Close()
+	err = db.Close()
 	assert.Nil(t, err)
 
 	// 重启数据库
@@ -139,8 +150,7 @@ func TestDB_Get(t *testing.T) {
 	assert.NotNil(t, val5)
 
 	// 6.重启后，前面写入的数据都能拿到
-	db.Close() // todo 实现 Close 方法后这里用 Close() 替代
-	err = db.activeFile.Close()
+	err = db.Close()
 	assert.Nil(t, err)
 
 	// 重启数据库
@@ -316,3 +326,757 @@ func TestDB_Sync(t *testing.T) {
 	err = db.Sync()
 	assert.Nil(t, err)
 }
+
+func TestDB_FileLock(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-filelock")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 目录被占用时无法再次打开
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	// 关闭之后可以重新打开
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Stat(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 100; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 2000; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	stat := getStat(t, db)
+	assert.Equal(t, uint(9000), stat.KeyNum)
+	assert.Equal(t, uint(1), stat.DataFileNum)
+	assert.True(t, stat.ReclaimableSize > 0)
+	assert.True(t, stat.DiskSize > 0)
+
+	// 重启之后从数据文件中重新统计可回收的数据量
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, getStat(t, db2).ReclaimableSize)
+
+	// 无法读取数据目录时返回错误
+	err = os.RemoveAll(dir)
+	assert.Nil(t, err)
+	_, err = db2.Stat()
+	assert.NotNil(t, err)
+}
+
+func TestDB_BytesPerSync(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bytes-sync")
+	opts.DirPath = dir
+	opts.BytesPerSync = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	unsynced := getStat(t, db).UnsyncedSize
+	assert.True(t, unsynced > 0)
+
+	// 累计写入超过阈值之后自动持久化
+	var synced bool
+	for i := 2; i < 100 && !synced; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+		unsynced = getStat(t, db).UnsyncedSize
+		assert.True(t, unsynced < int64(opts.BytesPerSync))
+		synced = unsynced == 0
+	}
+	assert.True(t, synced)
+
+	err = db.Put(utils.GetTestKey(0), utils.RandomValue(128))
+	assert.Nil(t, err)
+	assert.True(t, getStat(t, db).UnsyncedSize > 0)
+	err = db.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), getStat(t, db).UnsyncedSize)
+}
+
+func TestDB_SyncInterval(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-interval-sync")
+	opts.DirPath = dir
+	opts.SyncInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(128))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return getStat(t, db).UnsyncedSize == 0
+	}, time.Second, 5*time.Millisecond)
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+	ttl, err := db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+	ttl, err = db.TTL(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	time.Sleep(150 * time.Millisecond)
+
+	// 过期之后视为不存在
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	var folded int
+	err = db.Fold(func(k []byte, v []byte) bool {
+		folded++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, folded)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var iterated int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.NotEqual(t, utils.GetTestKey(1), iter.Key())
+		iterated++
+	}
+	iter.Close()
+	assert.Equal(t, 2, iterated)
+
+	// 重启之后过期时间依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	ttl, err = db2.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+
+	// merge 时丢弃过期的数据
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, db3.index.Size())
+	_, err = db3.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	entries, _ := os.ReadDir(dir)
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	db2, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+
+	// 只读实例之间可以共享目录，但不能再以读写模式打开
+	db3, err := Open(roOpts)
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	val, err := db2.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+
+	// 所有的写操作都会失败
+	err = db2.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Equal(t, ErrReadOnly, err)
+	err = db2.Delete(utils.GetTestKey(1))
+	assert.Equal(t, ErrReadOnly, err)
+	err = db2.Merge()
+	assert.Equal(t, ErrReadOnly, err)
+	wb, err := db2.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Nil(t, wb)
+	_, err = db2.PutIfAbsent(utils.GetTestKey(1000), utils.RandomValue(24))
+	assert.Equal(t, ErrReadOnly, err)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 目录中的文件没有发生变化
+	entries2, _ := os.ReadDir(dir)
+	assert.Equal(t, len(entries), len(entries2))
+
+	// 读写实例正在使用时不能以只读模式打开
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	err = db4.Close()
+	assert.Nil(t, err)
+
+	// 没有文件锁时不会创建
+	lockFileName := filepath.Join(dir, fileLockName)
+	err = os.Remove(lockFileName)
+	assert.Nil(t, err)
+	db5, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db5.ListKeys()))
+	_, err = os.Stat(lockFileName)
+	assert.True(t, os.IsNotExist(err))
+	err = db5.Close()
+	assert.Nil(t, err)
+
+	// 目录不存在时不会创建
+	roOpts.DirPath = dir + "-not-exist"
+	_, err = Open(roOpts)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_RecoverTornTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	activeFileId := db.activeFile.FileId
+	writeOff := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, db.RecoveryReport())
+
+	// 模拟崩溃，最后一条数据只写入了一部分
+	enc, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(100), nonTransactionSeqNo),
+		Value: utils.RandomValue(24),
+	})
+	f, err := os.OpenFile(data.GetDataFileName(dir, activeFileId), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(enc[:size/2])
+	assert.Nil(t, err)
+	_ = f.Close()
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	report := db2.RecoveryReport()
+	assert.NotNil(t, report)
+	assert.Equal(t, activeFileId, report.FileId)
+	assert.Equal(t, writeOff, report.Offset)
+	assert.Equal(t, size/2, report.TruncatedBytes)
+	assert.Equal(t, io.ErrUnexpectedEOF, report.Cause)
+	assert.Equal(t, writeOff, db2.activeFile.WriteOff)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+
+	// 截断之后继续写入，重启后数据完整
+	err = db2.Put(utils.GetTestKey(100), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Nil(t, db3.RecoveryReport())
+	assert.Equal(t, 101, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_MMapAtStartup(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	activeFileId := db.activeFile.FileId
+	writeOff := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 活跃文件末尾写入不完整的数据
+	enc, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(1000), nonTransactionSeqNo),
+		Value: utils.RandomValue(24),
+	})
+	f, err := os.OpenFile(data.GetDataFileName(dir, activeFileId), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(enc[:size/2])
+	assert.Nil(t, err)
+	_ = f.Close()
+
+	opts.MMapAtStartup = true
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.RecoveryReport())
+	assert.Equal(t, writeOff, db2.activeFile.WriteOff)
+	assert.Equal(t, 900, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 重新打开之后可以正常写入
+	err = db2.Put(utils.GetTestKey(1000), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 901, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_InMemory(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-in-memory")
+	opts.DataFileSize = 32 * 1024
+	opts.InMemory = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 900, len(db.ListKeys()))
+	stat := getStat(t, db)
+	assert.True(t, stat.DataFileNum > 1)
+	assert.True(t, stat.DiskSize > 0)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 不会在磁盘上创建任何文件
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_FileSystem(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-fs"
+	opts.DataFileSize = 32 * 1024
+	opts.FS = fio.NewMemFS()
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 同一个文件系统上重新打开，merge 的结果生效
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db2.ListKeys()))
+	_, err = opts.FS.Stat(db2.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 备份到同一个文件系统中
+	err = db2.Backup("/bitcask-go-fs-backup")
+	assert.Nil(t, err)
+	opts2 := opts
+	opts2.DirPath = "/bitcask-go-fs-backup"
+	db3, err := Open(opts2)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db3.ListKeys()))
+
+	// B+ 树索引只支持操作系统的文件系统
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_PreallocateDataFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-prealloc")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.PreallocateDataFile = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 活跃文件预分配了空间，旧的数据文件被截断
+	stat, err := os.Stat(data.GetDataFileName(dir, db.activeFile.FileId))
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, stat.Size())
+	assert.True(t, len(db.olderFiles) > 0)
+	for fid, file := range db.olderFiles {
+		stat, err := os.Stat(data.GetDataFileName(dir, fid))
+		assert.Nil(t, err)
+		assert.Equal(t, file.WriteOff, stat.Size())
+	}
+
+	// 模拟崩溃，活跃文件末尾是预分配的空间，最后一条数据只写入了一部分
+	activeFileId := db.activeFile.FileId
+	writeOff := db.activeFile.WriteOff
+	err = db.activeFile.Sync()
+	assert.Nil(t, err)
+	err = db.fileLock.Unlock()
+	assert.Nil(t, err)
+	enc, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(1000), nonTransactionSeqNo),
+		Value: utils.RandomValue(128),
+	})
+	f, err := os.OpenFile(data.GetDataFileName(dir, activeFileId), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt(enc[:size-3], writeOff)
+	assert.Nil(t, err)
+	_ = f.Close()
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.RecoveryReport())
+	assert.Equal(t, writeOff, db2.activeFile.WriteOff)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+
+	err = db2.Put(utils.GetTestKey(1000), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	// 关闭时截掉预分配的空间
+	stat, err = os.Stat(data.GetDataFileName(dir, activeFileId))
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff+size, stat.Size())
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Nil(t, db3.RecoveryReport())
+	assert.Equal(t, 1001, len(db3.ListKeys()))
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_PreallocateDataFile_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-prealloc-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.PreallocateDataFile = true
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	writeOff := db.activeFile.WriteOff
+	// 模拟崩溃，没有截掉预分配的空间
+	err = db.index.Close()
+	assert.Nil(t, err)
+	err = db.fileLock.Unlock()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, db2.activeFile.WriteOff)
+	err = db2.Put(utils.GetTestKey(100), utils.RandomValue(128))
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	val, err = db2.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	activeFileId := db2.activeFile.FileId
+	writeOff = db2.activeFile.WriteOff
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 最后一条数据只写入了一部分，之后是预分配的空间，截断并记录恢复信息
+	enc, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(101), nonTransactionSeqNo),
+		Value: utils.RandomValue(128),
+	})
+	fileName := data.GetDataFileName(dir, activeFileId)
+	f, err := os.OpenFile(fileName, os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt(enc[:size-3], writeOff)
+	assert.Nil(t, err)
+	err = f.Truncate(opts.DataFileSize)
+	assert.Nil(t, err)
+	_ = f.Close()
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	report := db3.RecoveryReport()
+	assert.NotNil(t, report)
+	assert.Equal(t, writeOff, report.Offset)
+	assert.Equal(t, data.ErrInvalidCRC, report.Cause)
+	assert.Equal(t, writeOff, db3.activeFile.WriteOff)
+	headerSize := db3.activeFile.HeaderSize
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 活跃文件中间的数据被破坏，之后的数据不能被截掉
+	f, err = os.OpenFile(fileName, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, headerSize)
+	assert.Nil(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, headerSize)
+	assert.Nil(t, err)
+	_ = f.Close()
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, stat.Size())
+}
+
+func TestDB_BlockCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-block-cache")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DirectIO = true
+	opts.BlockCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	// 覆盖写入之后读到的是新的数据
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+	}
+	stat := getStat(t, db)
+	assert.True(t, stat.BlockCacheHits > 0)
+	assert.True(t, stat.BlockCacheMiss > 0)
+	assert.True(t, stat.BlockCacheSize > 0)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.True(t, getStat(t, db2).BlockCacheSize > 0)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	opts.DirPath = dir
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+	stat := getStat(t, db)
+	assert.Equal(t, uint64(100), stat.ValueCacheMiss)
+	assert.Equal(t, uint64(200), stat.ValueCacheHits)
+	assert.True(t, stat.ValueCacheSize > 0)
+
+	// 更新和删除之后读不到旧的数据
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 修改返回的数据不影响缓存中的数据
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	val[0] = 'x'
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(3), val)
+}
+
+func TestDB_CorruptedOlderFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-corrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 旧的数据文件中间被破坏，启动失败
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("broken"), 100)
+	assert.Nil(t, err)
+	_ = f.Close()
+
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
@@ -13,4 +13,10 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrMergeNotInstalled      = errors.New("a finished merge has not been installed, reopen the database")
+	ErrBackupNotSupported     = errors.New("backup is not supported for the B+ tree index")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys read have been modified")
+	ErrTxnClosed              = errors.New("the transaction has been committed or rolled back")
+	ErrReadOnly               = errors.New("the database is opened in read-only mode")
 )
@@ -0,0 +1,453 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openFaultDB(t *testing.T, faultFS *fio.FaultFS, opts Options) *DB {
+	opts.FS = faultFS
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db
+}
+
+// checkDBData 校验数据库中的数据和预期的完全一致
+func checkDBData(t *testing.T, db *DB, expected map[string][]byte) {
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func faultOptions() Options {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-fault"
+	opts.DataFileSize = 32 * 1024
+	return opts
+}
+
+func TestDB_Fault_Put(t *testing.T) {
+	opts := faultOptions()
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	db := openFaultDB(t, faultFS, opts)
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 500; i++ {
+		value := utils.RandomValue(24)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	err := db.Sync()
+	assert.Nil(t, err)
+
+	// 没有持久化的数据在崩溃之后丢失
+	for i := 500; i < 600; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db2 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db2, expected)
+
+	// 写入了一部分数据之后失败
+	faultFS.FailWriteAt(1, 10)
+	err = db2.Put(utils.GetTestKey(600), utils.RandomValue(24))
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	_, err = db2.Get(utils.GetTestKey(600))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	value := utils.RandomValue(24)
+	err = db2.Put(utils.GetTestKey(601), value)
+	assert.Nil(t, err)
+	expected[string(utils.GetTestKey(601))] = value
+	checkDBData(t, db2, expected)
+	err = db2.Sync()
+	assert.Nil(t, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db3 := openFaultDB(t, faultFS, opts)
+	assert.Nil(t, db3.RecoveryReport())
+	checkDBData(t, db3, expected)
+
+	// 持久化失败
+	opts.SyncWrites = true
+	db4 := openFaultDB(t, faultFS, opts)
+	faultFS.FailSync(true)
+	err = db4.Put(utils.GetTestKey(602), utils.RandomValue(24))
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	_, err = db4.Get(utils.GetTestKey(602))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db5 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db5, expected)
+}
+
+func TestDB_Fault_WriteBatch(t *testing.T) {
+	opts := faultOptions()
+	opts.SyncWrites = true
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	db := openFaultDB(t, faultFS, opts)
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		value := utils.RandomValue(24)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+
+	// 每条数据都会持久化，写到一半时进程退出
+	wb, err := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		err := wb.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 100; i < 110; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	faultFS.FailWriteAt(8, 5)
+	err = wb.Commit()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	// 没有完成的事务不可见
+	db2 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db2, expected)
+
+	// 事务完成标识没有写入
+	wb, err = db2.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
+	for i := 100; i < 110; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	faultFS.FailWriteAt(11, 0)
+	err = wb.Commit()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db3 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db3, expected)
+
+	// 提交成功之后的数据在崩溃之后依然存在
+	wb, err = db3.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		err := wb.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	for i := 100; i < 110; i++ {
+		value := utils.RandomValue(24)
+		err := wb.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db4 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db4, expected)
+}
+
+func TestDB_Fault_Merge(t *testing.T) {
+	opts := faultOptions()
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	db := openFaultDB(t, faultFS, opts)
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 2000; i++ {
+		value := utils.RandomValue(24)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	err := db.Sync()
+	assert.Nil(t, err)
+
+	// merge 过程中写入失败
+	faultFS.FailWriteAt(100, 3)
+	err = db.Merge()
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	// 没有完成的 merge 被丢弃
+	db2 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db2, expected)
+	_, err = faultFS.Stat(db2.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	dataFileNum := getStat(t, db2).DataFileNum
+
+	// merge 完成之后崩溃，重启时使用 merge 之后的数据
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db3 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db3, expected)
+	assert.True(t, getStat(t, db3).DataFileNum < dataFileNum)
+}
+
+func TestDB_Fault_MergeInstallOnline(t *testing.T) {
+	opts := faultOptions()
+	opts.MergeInstallOnline = true
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	db := openFaultDB(t, faultFS, opts)
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 2000; i++ {
+		value := utils.RandomValue(24)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	dataFileNum := getStat(t, db).DataFileNum
+
+	// 在线安装之后继续写入，然后崩溃
+	err := db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, getStat(t, db).DataFileNum < dataFileNum)
+	for i := 2000; i < 2100; i++ {
+		value := utils.RandomValue(24)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	err = db.Sync()
+	assert.Nil(t, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+
+	db2 := openFaultDB(t, faultFS, opts)
+	checkDBData(t, db2, expected)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+// putMergeData 写入数据并删除一半，返回预期的数据
+func putMergeData(t *testing.T, db *DB) map[string][]byte {
+	expected := make(map[string][]byte)
+	for i := 0; i < 2000; i++ {
+		value := utils.RandomValue(24)
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	err := db.Sync()
+	assert.Nil(t, err)
+	return expected
+}
+
+func TestDB_Fault_MergeInstallInterrupted(t *testing.T) {
+	opts := faultOptions()
+
+	// 启动时移动 merge 的文件，第 n 次重命名失败之后崩溃，直到没有失败为止
+	for n := 1; ; n++ {
+		faultFS := fio.NewFaultFS(fio.NewMemFS())
+		db := openFaultDB(t, faultFS, opts)
+		expected := putMergeData(t, db)
+		dataFileNum := getStat(t, db).DataFileNum
+		err := db.Merge()
+		assert.Nil(t, err)
+		err = db.Close()
+		assert.Nil(t, err)
+
+		faultFS.FailRenameAt(n)
+		opts.FS = faultFS
+		db2, err := Open(opts)
+		if err == nil {
+			checkDBData(t, db2, expected)
+			faultFS.FailRenameAt(0)
+			err = db2.Close()
+			assert.Nil(t, err)
+			break
+		}
+		assert.Equal(t, fio.ErrInjectedFault, err)
+		err = faultFS.Crash()
+		assert.Nil(t, err)
+
+		// 重启时继续完成移动
+		db3 := openFaultDB(t, faultFS, opts)
+		checkDBData(t, db3, expected)
+		assert.True(t, getStat(t, db3).DataFileNum < dataFileNum)
+		_, err = faultFS.Stat(db3.getMergePath())
+		assert.True(t, os.IsNotExist(err))
+		err = db3.Close()
+		assert.Nil(t, err)
+	}
+
+	// 在线安装时第 n 次重命名失败之后崩溃
+	opts.MergeInstallOnline = true
+	for n := 1; ; n++ {
+		faultFS := fio.NewFaultFS(fio.NewMemFS())
+		db := openFaultDB(t, faultFS, opts)
+		expected := putMergeData(t, db)
+
+		faultFS.FailRenameAt(n)
+		err := db.Merge()
+		if err == nil {
+			checkDBData(t, db, expected)
+			faultFS.FailRenameAt(0)
+			err = db.Close()
+			assert.Nil(t, err)
+			break
+		}
+		assert.Equal(t, fio.ErrInjectedFault, err)
+		// 移动文件失败时内存中仍然使用原来打开的文件，或者已经替换完成，读取不受影响
+		checkDBData(t, db, expected)
+		// 安装中途失败的 merge 不能被新的 merge 删除
+		if db.hasFinishedMerge() {
+			err = db.Merge()
+			assert.Equal(t, ErrMergeNotInstalled, err)
+		}
+		err = faultFS.Crash()
+		assert.Nil(t, err)
+
+		db2 := openFaultDB(t, faultFS, opts)
+		checkDBData(t, db2, expected)
+		err = db2.Close()
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_Fault_MergeNoEnoughSpace(t *testing.T) {
+	opts := faultOptions()
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	db := openFaultDB(t, faultFS, opts)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 可用空间放不下 merge 之后的数据，不会创建 merge 目录
+	faultFS.SetFreeSpace(16 * 1024)
+	err := db.Merge()
+	assert.Equal(t, ErrNoEnoughSpaceForMerge, err)
+	_, err = faultFS.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 空间足够但是不满足预留的空间
+	faultFS.SetFreeSpace(1024 * 1024)
+	db.options.MergeFreeSpaceMargin = 1024 * 1024
+	err = db.Merge()
+	assert.Equal(t, ErrNoEnoughSpaceForMerge, err)
+
+	db.options.MergeFreeSpaceMargin = 0
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	faultFS.SetFreeSpace(-1)
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Fault_Corrupt(t *testing.T) {
+	opts := faultOptions()
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	db := openFaultDB(t, faultFS, opts)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	activeFile := data.GetDataFileName(opts.DirPath, db.activeFile.FileId)
+	writeOff := db.activeFile.WriteOff
+	err := db.Close()
+	assert.Nil(t, err)
+
+	// 活跃文件的最后一条数据被破坏，截断之后正常启动
+	err = faultFS.Corrupt(activeFile, writeOff-1)
+	assert.Nil(t, err)
+	db2 := openFaultDB(t, faultFS, opts)
+	report := db2.RecoveryReport()
+	assert.NotNil(t, report)
+	assert.Equal(t, data.ErrInvalidCRC, report.Cause)
+	assert.Equal(t, 1999, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 旧的数据文件被破坏，启动失败
+	err = faultFS.Corrupt(data.GetDataFileName(opts.DirPath, 0), 100)
+	assert.Nil(t, err)
+	opts.FS = faultFS
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
+
+func TestDB_Fault_WriteSeqNoFile(t *testing.T) {
+	faultFS := fio.NewFaultFS(fio.NewMemFS())
+	dir := "/bitcask-go-fault-seq-no"
+	err := faultFS.MkdirAll(dir)
+	assert.Nil(t, err)
+
+	readSeqNo := func() string {
+		seqNoFile, err := data.OpenSeqNoFile(faultFS, dir, fio.StandardFIO)
+		assert.Nil(t, err)
+		defer seqNoFile.Close()
+		record, _, err := seqNoFile.ReadLogRecord(seqNoFile.HeaderSize)
+		assert.Nil(t, err)
+		return string(record.Value)
+	}
+
+	err = writeSeqNoFile(faultFS, dir, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "10", readSeqNo())
+
+	// 写到一半时进程退出，原来的文件仍然完整
+	faultFS.FailWriteAt(2, 3)
+	err = writeSeqNoFile(faultFS, dir, 20)
+	assert.Equal(t, fio.ErrInjectedFault, err)
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+	assert.Equal(t, "10", readSeqNo())
+
+	// 重新写入时覆盖上次留下的临时文件
+	err = writeSeqNoFile(faultFS, dir, 30)
+	assert.Nil(t, err)
+	assert.Equal(t, "30", readSeqNo())
+	_, err = faultFS.Stat(filepath.Join(dir, data.SeqNoTempFileName))
+	assert.True(t, os.IsNotExist(err))
+}
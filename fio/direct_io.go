@@ -0,0 +1,66 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// directIOAlignment O_DIRECT 要求读取的位置、长度以及内存地址都按照块大小对齐
+const directIOAlignment = 4096
+
+// DirectIO 读取时绕过操作系统页缓存的文件 IO，写入仍然使用标准文件 IO
+type DirectIO struct {
+	*FileIO
+	rfd *os.File
+}
+
+// NewDirectIOManager 初始化 DirectIO，文件系统不支持 O_DIRECT 时退化为普通的读取
+func NewDirectIOManager(fileName string) (*DirectIO, error) {
+	fileIO, err := NewFileIOManager(fileName)
+	if err != nil {
+		return nil, err
+	}
+	rfd, err := openDirect(fileName)
+	if err != nil {
+		_ = fileIO.Close()
+		return nil, err
+	}
+	return &DirectIO{FileIO: fileIO, rfd: rfd}, nil
+}
+
+func (dio *DirectIO) Read(b []byte, offset int64) (int, error) {
+	start := offset &^ (directIOAlignment - 1)
+	end := (offset + int64(len(b)) + directIOAlignment - 1) &^ (directIOAlignment - 1)
+	buf := alignedBlock(int(end - start))
+	n, err := dio.rfd.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if int64(n) <= offset-start {
+		return 0, io.EOF
+	}
+	copied := copy(b, buf[offset-start:n])
+	if copied < len(b) {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+func (dio *DirectIO) Close() error {
+	if err := dio.rfd.Close(); err != nil {
+		_ = dio.FileIO.Close()
+		return err
+	}
+	return dio.FileIO.Close()
+}
+
+// alignedBlock 分配内存地址按照块大小对齐的字节数组
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		off = directIOAlignment - rem
+	}
+	return buf[off : off+size]
+}
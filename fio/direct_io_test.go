@@ -0,0 +1,39 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDirectIO_Read(t *testing.T) {
+	path, _ := os.CreateTemp("", "a.data")
+	defer os.Remove(path.Name())
+	dio, err := NewDirectIOManager(path.Name())
+	assert.Nil(t, err)
+	defer dio.Close()
+
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_, err = dio.Write(data)
+	assert.Nil(t, err)
+
+	// 读取的位置和长度都没有对齐
+	b := make([]byte, 5000)
+	n, err := dio.Read(b, 3000)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, n)
+	assert.Equal(t, data[3000:8000], b)
+
+	// 读到文件末尾
+	n, err = dio.Read(b, 9000)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, data[9000:], b[:n])
+
+	_, err = dio.Read(b, 10000)
+	assert.Equal(t, io.EOF, err)
+}
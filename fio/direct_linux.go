@@ -0,0 +1,18 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// openDirect 以 O_DIRECT 的方式只读打开文件，文件系统不支持时使用普通的方式打开
+func openDirect(fileName string) (*os.File, error) {
+	fd, err := os.OpenFile(fileName, os.O_RDONLY|syscall.O_DIRECT, DataFilePerm)
+	if errors.Is(err, syscall.EINVAL) {
+		return os.OpenFile(fileName, os.O_RDONLY, DataFilePerm)
+	}
+	return fd, err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// openDirect 不支持 O_DIRECT 的系统上使用普通的方式打开
+func openDirect(fileName string) (*os.File, error) {
+	return os.OpenFile(fileName, os.O_RDONLY, DataFilePerm)
+}
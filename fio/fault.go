@@ -0,0 +1,291 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultFS 故障注入文件系统，包装其他的文件系统，用于测试写入失败和崩溃恢复
+// 可以指定第 N 次写入失败、持久化失败、破坏文件中的数据，以及模拟崩溃丢弃没有持久化的数据
+type FaultFS struct {
+	FS
+	mu           sync.Mutex
+	writes       int                   // 累计写入的次数
+	failWriteAt  int                   // 第几次写入失败，为 0 表示不开启
+	shortWrite   int                   // 失败的写入中实际写入的字节数
+	renames      int                   // 累计重命名的次数
+	failRenameAt int                   // 第几次重命名失败，为 0 表示不开启
+	failSync     bool                  // 持久化是否失败
+	crashed      bool                  // 是否已经模拟崩溃
+	freeSpace    int64                 // 模拟的可用空间，小于 0 表示使用被包装的文件系统的可用空间
+	synced       map[string]int64      // 每个文件已经持久化的数据量
+	opened       map[string][]*FaultIO // 崩溃之前打开的文件
+}
+
+// FaultIO 故障注入的 IOManager
+type FaultIO struct {
+	IOManager
+	fs      *FaultFS
+	name    string
+	crashed bool
+}
+
+// NewFaultFS 初始化故障注入文件系统
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{
+		FS:        fs,
+		freeSpace: -1,
+		synced:    make(map[string]int64),
+		opened:    make(map[string][]*FaultIO),
+	}
+}
+
+// FailWriteAt 从现在开始的第 n 次写入失败，失败之前实际写入 shortWrite 个字节
+func (f *FaultFS) FailWriteAt(n int, shortWrite int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = 0
+	f.failWriteAt = n
+	f.shortWrite = shortWrite
+}
+
+// FailRenameAt 从现在开始的第 n 次重命名失败，用于模拟移动文件到一半时进程退出
+func (f *FaultFS) FailRenameAt(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renames = 0
+	f.failRenameAt = n
+}
+
+// FailSync 设置持久化是否失败
+func (f *FaultFS) FailSync(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failSync = fail
+}
+
+// SetFreeSpace 设置文件系统的可用空间，小于 0 表示使用被包装的文件系统的可用空间
+func (f *FaultFS) SetFreeSpace(size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.freeSpace = size
+}
+
+// Corrupt 将文件 offset 位置的一个字节取反
+func (f *FaultFS) Corrupt(name string, offset int64) error {
+	ioManager, err := f.FS.OpenFile(name, StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer ioManager.Close()
+	size, err := ioManager.Size()
+	if err != nil {
+		return err
+	}
+	if offset >= size {
+		return io.EOF
+	}
+	// 文件只支持追加写，截断之后重新写入
+	tail := make([]byte, size-offset)
+	if _, err := ioManager.Read(tail, offset); err != nil {
+		return err
+	}
+	tail[0] ^= 0xff
+	if err := ioManager.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := ioManager.Write(tail); err != nil {
+		return err
+	}
+	return ioManager.Sync()
+}
+
+// Crash 模拟进程崩溃，所有文件丢弃没有持久化的数据，崩溃之前打开的文件都不能再使用
+func (f *FaultFS) Crash() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, files := range f.opened {
+		for _, file := range files {
+			file.crashed = true
+		}
+	}
+	f.opened = make(map[string][]*FaultIO)
+
+	for name, synced := range f.synced {
+		if _, err := f.FS.Stat(name); os.IsNotExist(err) {
+			continue
+		}
+		ioManager, err := f.FS.OpenFile(name, StandardFIO)
+		if err != nil {
+			return err
+		}
+		size, err := ioManager.Size()
+		if err == nil && size > synced {
+			err = ioManager.Truncate(synced)
+		}
+		_ = ioManager.Close()
+		if err != nil {
+			return err
+		}
+	}
+	f.synced = make(map[string]int64)
+	f.failWriteAt = 0
+	f.failRenameAt = 0
+	f.failSync = false
+	return nil
+}
+
+func (f *FaultFS) OpenFile(name string, ioType FileIOType) (IOManager, error) {
+	name = filepath.Clean(name)
+	ioManager, err := f.FS.OpenFile(name, ioType)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// 打开时已经存在的数据认为是已经持久化的
+	if _, ok := f.synced[name]; !ok {
+		size, err := ioManager.Size()
+		if err != nil {
+			_ = ioManager.Close()
+			return nil, err
+		}
+		f.synced[name] = size
+	}
+	file := &FaultIO{IOManager: ioManager, fs: f, name: name}
+	f.opened[name] = append(f.opened[name], file)
+	return file, nil
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if err := f.FS.Remove(name); err != nil {
+		return err
+	}
+	f.forget(name)
+	return nil
+}
+
+func (f *FaultFS) RemoveAll(path string) error {
+	if err := f.FS.RemoveAll(path); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path = filepath.Clean(path)
+	for name := range f.synced {
+		if name == path || isChild(path, name) {
+			delete(f.synced, name)
+		}
+	}
+	return nil
+}
+
+func (f *FaultFS) Rename(oldPath, newPath string) error {
+	f.mu.Lock()
+	f.renames++
+	fail := f.failRenameAt > 0 && f.renames == f.failRenameAt
+	f.mu.Unlock()
+	if fail {
+		return ErrInjectedFault
+	}
+	if err := f.FS.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if synced, ok := f.synced[oldPath]; ok {
+		delete(f.synced, oldPath)
+		f.synced[newPath] = synced
+	}
+	return nil
+}
+
+func (f *FaultFS) FreeSpace(dirPath string) (int64, error) {
+	f.mu.Lock()
+	freeSpace := f.freeSpace
+	f.mu.Unlock()
+	if freeSpace >= 0 {
+		return freeSpace, nil
+	}
+	return f.FS.FreeSpace(dirPath)
+}
+
+func (f *FaultFS) forget(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.synced, filepath.Clean(name))
+}
+
+func (fio *FaultIO) Write(b []byte) (int, error) {
+	fio.fs.mu.Lock()
+	if fio.crashed {
+		fio.fs.mu.Unlock()
+		return 0, ErrInjectedFault
+	}
+	fio.fs.writes++
+	if fio.fs.failWriteAt > 0 && fio.fs.writes == fio.fs.failWriteAt {
+		shortWrite := fio.fs.shortWrite
+		fio.fs.mu.Unlock()
+		if shortWrite > len(b) {
+			shortWrite = len(b)
+		}
+		n, err := fio.IOManager.Write(b[:shortWrite])
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjectedFault
+	}
+	fio.fs.mu.Unlock()
+	return fio.IOManager.Write(b)
+}
+
+func (fio *FaultIO) Sync() error {
+	fio.fs.mu.Lock()
+	defer fio.fs.mu.Unlock()
+	if fio.crashed || fio.fs.failSync {
+		return ErrInjectedFault
+	}
+	if err := fio.IOManager.Sync(); err != nil {
+		return err
+	}
+	size, err := fio.IOManager.Size()
+	if err != nil {
+		return err
+	}
+	fio.fs.synced[fio.name] = size
+	return nil
+}
+
+func (fio *FaultIO) Truncate(size int64) error {
+	fio.fs.mu.Lock()
+	defer fio.fs.mu.Unlock()
+	if fio.crashed {
+		return ErrInjectedFault
+	}
+	if err := fio.IOManager.Truncate(size); err != nil {
+		return err
+	}
+	if fio.fs.synced[fio.name] > size {
+		fio.fs.synced[fio.name] = size
+	}
+	return nil
+}
+
+func (fio *FaultIO) Close() error {
+	fio.fs.mu.Lock()
+	defer fio.fs.mu.Unlock()
+	files := fio.fs.opened[fio.name]
+	for i, file := range files {
+		if file == fio {
+			fio.fs.opened[fio.name] = append(files[:i], files[i+1:]...)
+			break
+		}
+	}
+	return fio.IOManager.Close()
+}
@@ -0,0 +1,63 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFaultFS(t *testing.T) {
+	faultFS := NewFaultFS(NewMemFS())
+	err := faultFS.MkdirAll("/bitcask")
+	assert.Nil(t, err)
+	fio, err := faultFS.OpenFile("/bitcask/a.data", StandardFIO)
+	assert.Nil(t, err)
+
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	err = fio.Sync()
+	assert.Nil(t, err)
+
+	// 第二次写入只写入了一部分
+	faultFS.FailWriteAt(2, 2)
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	n, err := fio.Write([]byte("key-c"))
+	assert.Equal(t, ErrInjectedFault, err)
+	assert.Equal(t, 2, n)
+
+	faultFS.FailSync(true)
+	assert.Equal(t, ErrInjectedFault, fio.Sync())
+	faultFS.FailSync(false)
+
+	// 崩溃之后没有持久化的数据丢失，之前打开的文件不能再写入
+	err = faultFS.Crash()
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-d"))
+	assert.Equal(t, ErrInjectedFault, err)
+
+	fio, err = faultFS.OpenFile("/bitcask/a.data", StandardFIO)
+	assert.Nil(t, err)
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+
+	err = faultFS.Corrupt("/bitcask/a.data", 4)
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-"), b[:4])
+	assert.Equal(t, byte('a')^0xff, b[4])
+}
+
+func TestFaultFS_FreeSpace(t *testing.T) {
+	faultFS := NewFaultFS(OSFS)
+	size, err := faultFS.FreeSpace(".")
+	assert.Nil(t, err)
+	assert.True(t, size > 0)
+
+	faultFS.SetFreeSpace(1024)
+	size, err = faultFS.FreeSpace(".")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), size)
+}
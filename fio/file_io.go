@@ -4,19 +4,36 @@ import "os"
 
 // FileIO 标准系统文件IO
 type FileIO struct {
-	fd *os.File
+	fd          *os.File
+	writeOff    int64 // 下一次写入的位置，预分配空间之后文件大小不再是数据的末尾
+	preallocate bool  // 是否预分配了文件空间
 }
 
 // NewFileIOManager 初始化标准文件IO
 func NewFileIOManager(filename string) (*FileIO, error) {
+	// 使用指定位置写入代替 O_APPEND，预分配空间之后才能继续在数据的末尾写入
 	fd, err := os.OpenFile(
 		filename,
-		os.O_CREATE|os.O_RDWR|os.O_APPEND,
+		os.O_CREATE|os.O_RDWR,
 		DataFilePerm,
 	)
 	if err != nil {
 		return nil, err
 	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &FileIO{fd: fd, writeOff: stat.Size()}, nil
+}
+
+// NewReadOnlyFileIOManager 以只读的方式打开已经存在的文件
+func NewReadOnlyFileIOManager(filename string) (*FileIO, error) {
+	fd, err := os.OpenFile(filename, os.O_RDONLY, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
 	return &FileIO{fd: fd}, nil
 }
 
@@ -25,10 +42,16 @@ func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 }
 
 func (fio *FileIO) Write(b []byte) (int, error) {
-	return fio.fd.Write(b)
+	n, err := fio.fd.WriteAt(b, fio.writeOff)
+	fio.writeOff += int64(n)
+	return n, err
 }
 
 func (fio *FileIO) Sync() error {
+	// 预分配空间之后写入不会改变文件大小，只需要持久化数据
+	if fio.preallocate {
+		return datasync(fio.fd)
+	}
 	return fio.fd.Sync()
 }
 
@@ -43,3 +66,23 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+func (fio *FileIO) Truncate(size int64) error {
+	if err := fio.fd.Truncate(size); err != nil {
+		return err
+	}
+	fio.writeOff = size
+	fio.preallocate = false
+	return nil
+}
+
+func (fio *FileIO) Preallocate(size int64) error {
+	if size <= fio.writeOff {
+		return nil
+	}
+	if err := fallocate(fio.fd, size); err != nil {
+		return err
+	}
+	fio.preallocate = true
+	return nil
+}
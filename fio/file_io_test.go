@@ -7,7 +7,7 @@ import (
 )
 
 func TestNewFileIOManager(t *testing.T) {
-	path, _ := os.CreateTemp("../tmp", "a.data")
+	path, _ := os.CreateTemp("", "a.data")
 	fio, err := NewFileIOManager(path.Name())
 
 	assert.Nil(t, err)
@@ -15,7 +15,7 @@ func TestNewFileIOManager(t *testing.T) {
 }
 
 func TestFileIO_Write(t *testing.T) {
-	path, _ := os.CreateTemp("../tmp", "a.data")
+	path, _ := os.CreateTemp("", "a.data")
 	fio, err := NewFileIOManager(path.Name())
 
 	assert.Nil(t, err)
@@ -35,7 +35,7 @@ func TestFileIO_Write(t *testing.T) {
 }
 
 func TestFileIO_Read(t *testing.T) {
-	path, _ := os.CreateTemp("../tmp", "a.data")
+	path, _ := os.CreateTemp("", "a.data")
 	fio, err := NewFileIOManager(path.Name())
 
 	assert.Nil(t, err)
@@ -60,7 +60,7 @@ func TestFileIO_Read(t *testing.T) {
 }
 
 func TestFileIO_Sync(t *testing.T) {
-	path, _ := os.CreateTemp("../tmp", "a.data")
+	path, _ := os.CreateTemp("", "a.data")
 	fio, err := NewFileIOManager(path.Name())
 
 	assert.Nil(t, err)
@@ -71,7 +71,7 @@ func TestFileIO_Sync(t *testing.T) {
 }
 
 func TestFileIO_Close(t *testing.T) {
-	path, _ := os.CreateTemp("../tmp", "a.data")
+	path, _ := os.CreateTemp("", "a.data")
 	fio, err := NewFileIOManager(path.Name())
 
 	assert.Nil(t, err)
@@ -80,3 +80,34 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestFileIO_Preallocate(t *testing.T) {
+	path, _ := os.CreateTemp("", "a.data")
+	defer os.Remove(path.Name())
+	fio, err := NewFileIOManager(path.Name())
+	assert.Nil(t, err)
+
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	err = fio.Preallocate(1024)
+	assert.Nil(t, err)
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), size)
+
+	// 预分配之后继续在数据的末尾写入
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	err = fio.Sync()
+	assert.Nil(t, err)
+	b := make([]byte, 15)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-b\x00\x00\x00\x00\x00"), b)
+
+	err = fio.Truncate(10)
+	assert.Nil(t, err)
+	size, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+}
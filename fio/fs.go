@@ -0,0 +1,67 @@
+package fio
+
+import "os"
+
+// FS 抽象文件系统接口，数据目录中文件和目录的操作都通过它完成
+type FS interface {
+	// OpenFile 以指定的 IO 类型打开文件
+	OpenFile(name string, ioType FileIOType) (IOManager, error)
+
+	// ReadDir 读取目录中的文件，按照文件名排序
+	ReadDir(dirPath string) ([]os.DirEntry, error)
+
+	// Stat 获取文件信息，文件不存在时返回的错误满足 os.IsNotExist
+	Stat(name string) (os.FileInfo, error)
+
+	// MkdirAll 创建目录，父目录不存在时一并创建
+	MkdirAll(dirPath string) error
+
+	// Remove 删除文件或者空目录
+	Remove(name string) error
+
+	// RemoveAll 删除路径以及其中的所有文件
+	RemoveAll(path string) error
+
+	// Rename 重命名文件，目标文件存在时会被覆盖
+	Rename(oldPath, newPath string) error
+
+	// FreeSpace 获取目录所在的文件系统中可以使用的空间，字节为单位
+	FreeSpace(dirPath string) (int64, error)
+}
+
+// OSFS 操作系统的文件系统
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, ioType FileIOType) (IOManager, error) {
+	return NewIOManager(name, ioType)
+}
+
+func (osFS) ReadDir(dirPath string) ([]os.DirEntry, error) {
+	return os.ReadDir(dirPath)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(dirPath string) error {
+	return os.MkdirAll(dirPath, os.ModePerm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFS) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (osFS) FreeSpace(dirPath string) (int64, error) {
+	return freeSpace(dirPath)
+}
@@ -2,6 +2,27 @@ package fio
 
 const DataFilePerm = 0644
 
+type FileIOType = byte
+
+const (
+	// StandardFIO 标准文件 IO
+	StandardFIO FileIOType = iota
+
+	// ReadOnlyFIO 只读的标准文件 IO，不会创建文件
+	ReadOnlyFIO
+
+	// MemoryMap 内存文件映射，只读
+	MemoryMap
+
+	// DirectFIO 读取时使用 O_DIRECT 绕过页缓存，写入和标准文件 IO 相同
+	DirectFIO
+)
+
+// Writable 判断 IO 类型是否可以写入，可以写入的类型在文件不存在时会创建文件
+func Writable(ioType FileIOType) bool {
+	return ioType == StandardFIO || ioType == DirectFIO
+}
+
 // 抽象IO管理接口，可以接入不同的IO类型，比如文件io，mmp
 type IOManager interface {
 
@@ -19,9 +40,26 @@ type IOManager interface {
 
 	// Size 获取文件大小
 	Size() (int64, error)
+
+	// Truncate 将文件截断到指定大小，之后的写入从 size 开始
+	Truncate(size int64) error
+
+	// Preallocate 预先分配文件空间到指定大小，不影响写入的位置
+	Preallocate(size int64) error
 }
 
 // NewIOManager 初始化 IOManager
-func NewIOManager(fileName string) (IOManager, error) {
-	return NewFileIOManager(fileName)
+func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+	switch ioType {
+	case StandardFIO:
+		return NewFileIOManager(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
+	case MemoryMap:
+		return NewMMapIOManager(fileName)
+	case DirectFIO:
+		return NewDirectIOManager(fileName)
+	default:
+		panic("unsupported io type")
+	}
 }
@@ -0,0 +1,304 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrMemoryFileReadOnly = errors.New("memory file is opened read only")
+
+// MemFS 内存文件系统，所有的数据都保存在内存中，用于测试以及纯缓存的场景
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+	dirs  map[string]time.Time
+}
+
+// memFile 内存中的文件，多个 MemoryIO 可以同时打开同一个文件
+type memFile struct {
+	mu      sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS 初始化内存文件系统
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memFile),
+		dirs:  make(map[string]time.Time),
+	}
+}
+
+func (m *MemFS) OpenFile(name string, ioType FileIOType) (IOManager, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, ok := m.files[name]
+	if !ok {
+		// 只有可以写入的 IO 类型会创建文件
+		if !Writable(ioType) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if !m.dirExists(filepath.Dir(name)) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		file = &memFile{modTime: time.Now()}
+		m.files[name] = file
+	}
+	return &MemoryIO{file: file, readOnly: ioType == ReadOnlyFIO}, nil
+}
+
+func (m *MemFS) ReadDir(dirPath string) ([]os.DirEntry, error) {
+	dirPath = filepath.Clean(dirPath)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.dirExists(dirPath) {
+		return nil, &fs.PathError{Op: "readdir", Path: dirPath, Err: fs.ErrNotExist}
+	}
+	var entries []os.DirEntry
+	for name, file := range m.files {
+		if filepath.Dir(name) == dirPath {
+			entries = append(entries, fs.FileInfoToDirEntry(file.stat(filepath.Base(name))))
+		}
+	}
+	for name, modTime := range m.dirs {
+		if name != dirPath && filepath.Dir(name) == dirPath {
+			entries = append(entries, fs.FileInfoToDirEntry(&memFileInfo{name: filepath.Base(name), modTime: modTime, dir: true}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if file, ok := m.files[name]; ok {
+		return file.stat(filepath.Base(name)), nil
+	}
+	if modTime, ok := m.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), modTime: modTime, dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) MkdirAll(dirPath string) error {
+	dirPath = filepath.Clean(dirPath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dir := dirPath; !m.dirExists(dir); dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+		m.dirs[dir] = time.Now()
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if _, ok := m.dirs[name]; ok {
+		if m.hasChildren(name) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		delete(m.dirs, name)
+		return nil
+	}
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range m.files {
+		if name == path || isChild(path, name) {
+			delete(m.files, name)
+		}
+	}
+	for name := range m.dirs {
+		if name == path || isChild(path, name) {
+			delete(m.dirs, name)
+		}
+	}
+	return nil
+}
+
+// FreeSpace 内存文件系统不限制可以使用的空间
+func (m *MemFS) FreeSpace(dirPath string) (int64, error) {
+	return math.MaxInt64, nil
+}
+
+func (m *MemFS) Rename(oldPath, newPath string) error {
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.dirExists(filepath.Dir(newPath)) {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: fs.ErrNotExist}
+	}
+	if file, ok := m.files[oldPath]; ok {
+		delete(m.files, oldPath)
+		m.files[newPath] = file
+		return nil
+	}
+	if _, ok := m.dirs[oldPath]; !ok {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	// 移动目录以及其中的所有文件
+	for name, file := range m.files {
+		if isChild(oldPath, name) {
+			delete(m.files, name)
+			m.files[newPath+strings.TrimPrefix(name, oldPath)] = file
+		}
+	}
+	for name, modTime := range m.dirs {
+		if name == oldPath || isChild(oldPath, name) {
+			delete(m.dirs, name)
+			m.dirs[newPath+strings.TrimPrefix(name, oldPath)] = modTime
+		}
+	}
+	return nil
+}
+
+// dirExists 当前目录和根目录总是存在的
+func (m *MemFS) dirExists(dirPath string) bool {
+	if dirPath == "." || dirPath == string(filepath.Separator) {
+		return true
+	}
+	_, ok := m.dirs[dirPath]
+	return ok
+}
+
+func (m *MemFS) hasChildren(dirPath string) bool {
+	for name := range m.files {
+		if isChild(dirPath, name) {
+			return true
+		}
+	}
+	for name := range m.dirs {
+		if isChild(dirPath, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isChild(dirPath, name string) bool {
+	return strings.HasPrefix(name, strings.TrimSuffix(dirPath, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func (f *memFile) stat(name string) *memFileInfo {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &memFileInfo{name: name, size: int64(len(f.data)), modTime: f.modTime}
+}
+
+// memFileInfo 实现 os.FileInfo
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() any           { return nil }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | os.ModePerm
+	}
+	return DataFilePerm
+}
+
+// MemoryIO 内存文件 IO
+type MemoryIO struct {
+	file     *memFile
+	readOnly bool
+}
+
+func (mio *MemoryIO) Read(b []byte, offset int64) (int, error) {
+	mio.file.mu.RLock()
+	defer mio.file.mu.RUnlock()
+	if offset >= int64(len(mio.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mio.file.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mio *MemoryIO) Write(b []byte) (int, error) {
+	if mio.readOnly {
+		return 0, ErrMemoryFileReadOnly
+	}
+	mio.file.mu.Lock()
+	defer mio.file.mu.Unlock()
+	mio.file.data = append(mio.file.data, b...)
+	mio.file.modTime = time.Now()
+	return len(b), nil
+}
+
+// Sync 数据只保存在内存中，不需要持久化
+func (mio *MemoryIO) Sync() error {
+	return nil
+}
+
+func (mio *MemoryIO) Close() error {
+	return nil
+}
+
+func (mio *MemoryIO) Size() (int64, error) {
+	mio.file.mu.RLock()
+	defer mio.file.mu.RUnlock()
+	return int64(len(mio.file.data)), nil
+}
+
+// Preallocate 内存中不需要预分配空间
+func (mio *MemoryIO) Preallocate(int64) error {
+	return nil
+}
+
+func (mio *MemoryIO) Truncate(size int64) error {
+	if mio.readOnly {
+		return ErrMemoryFileReadOnly
+	}
+	mio.file.mu.Lock()
+	defer mio.file.mu.Unlock()
+	if size <= int64(len(mio.file.data)) {
+		mio.file.data = mio.file.data[:size]
+	} else {
+		mio.file.data = append(mio.file.data, make([]byte, size-int64(len(mio.file.data)))...)
+	}
+	mio.file.modTime = time.Now()
+	return nil
+}
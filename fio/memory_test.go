@@ -0,0 +1,91 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemFS_OpenFile(t *testing.T) {
+	memFS := NewMemFS()
+
+	// 目录不存在
+	_, err := memFS.OpenFile("/bitcask/a.data", StandardFIO)
+	assert.True(t, os.IsNotExist(err))
+
+	err = memFS.MkdirAll("/bitcask")
+	assert.Nil(t, err)
+	mio, err := memFS.OpenFile("/bitcask/a.data", StandardFIO)
+	assert.Nil(t, err)
+
+	n, err := mio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	_, err = mio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 5)
+	n, err = mio.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b)
+	_, err = mio.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+
+	// 只读打开的是同一个文件
+	rio, err := memFS.OpenFile("/bitcask/a.data", ReadOnlyFIO)
+	assert.Nil(t, err)
+	size, err := rio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+	_, err = rio.Write([]byte("key-c"))
+	assert.Equal(t, ErrMemoryFileReadOnly, err)
+	_, err = memFS.OpenFile("/bitcask/b.data", ReadOnlyFIO)
+	assert.True(t, os.IsNotExist(err))
+
+	err = mio.Truncate(5)
+	assert.Nil(t, err)
+	size, err = rio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+}
+
+func TestMemFS_Dir(t *testing.T) {
+	memFS := NewMemFS()
+	err := memFS.MkdirAll("/bitcask/merge")
+	assert.Nil(t, err)
+	for _, name := range []string{"/bitcask/b.data", "/bitcask/a.data", "/bitcask/merge/a.data"} {
+		mio, err := memFS.OpenFile(name, StandardFIO)
+		assert.Nil(t, err)
+		_, err = mio.Write([]byte("bitcask"))
+		assert.Nil(t, err)
+	}
+
+	entries, err := memFS.ReadDir("/bitcask")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "a.data", entries[0].Name())
+	assert.Equal(t, "b.data", entries[1].Name())
+	assert.True(t, entries[2].IsDir())
+
+	info, err := memFS.Stat("/bitcask/a.data")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), info.Size())
+
+	err = memFS.Remove("/bitcask/merge")
+	assert.NotNil(t, err)
+	err = memFS.Rename("/bitcask/merge/a.data", "/bitcask/c.data")
+	assert.Nil(t, err)
+	err = memFS.Remove("/bitcask/merge")
+	assert.Nil(t, err)
+	_, err = memFS.Stat("/bitcask/merge")
+	assert.True(t, os.IsNotExist(err))
+
+	err = memFS.RemoveAll("/bitcask")
+	assert.Nil(t, err)
+	_, err = memFS.Stat(filepath.Join("/bitcask", "c.data"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = memFS.ReadDir("/bitcask")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,78 @@
+package fio
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/exp/mmap"
+)
+
+var ErrMMapWriteNotSupported = errors.New("mmap io manager does not support write")
+
+// MMap 内存文件映射 IO，只用于启动时加速数据的读取
+type MMap struct {
+	fileName string
+	readerAt *mmap.ReaderAt
+}
+
+// NewMMapIOManager 初始化 MMap IO，文件必须已经存在
+func NewMMapIOManager(fileName string) (*MMap, error) {
+	readerAt, err := mmap.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &MMap{fileName: fileName, readerAt: readerAt}, nil
+}
+
+func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
+	return mmap.readerAt.ReadAt(b, offset)
+}
+
+func (mmap *MMap) Write([]byte) (int, error) {
+	return 0, ErrMMapWriteNotSupported
+}
+
+// Sync 映射是只读的，没有需要持久化的数据
+func (mmap *MMap) Sync() error {
+	return nil
+}
+
+func (mmap *MMap) Close() error {
+	return mmap.readerAt.Close()
+}
+
+func (mmap *MMap) Size() (int64, error) {
+	return int64(mmap.readerAt.Len()), nil
+}
+
+func (mmap *MMap) Preallocate(int64) error {
+	return ErrMMapWriteNotSupported
+}
+
+// Truncate 截断底层文件并重新建立映射
+func (mmap *MMap) Truncate(size int64) error {
+	fd, err := os.OpenFile(mmap.fileName, os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return err
+	}
+	if err := fd.Truncate(size); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := mmap.readerAt.Close(); err != nil {
+		return err
+	}
+	readerAt, err := NewMMapIOManager(mmap.fileName)
+	if err != nil {
+		return err
+	}
+	mmap.readerAt = readerAt.readerAt
+	return nil
+}
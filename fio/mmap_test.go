@@ -0,0 +1,71 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestMMap_Read(t *testing.T) {
+	path, _ := os.CreateTemp("", "mmap-a.data")
+	defer os.Remove(path.Name())
+
+	// 空文件
+	mmapIO, err := NewMMapIOManager(path.Name())
+	assert.Nil(t, err)
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+	_ = mmapIO.Close()
+
+	fio, err := NewFileIOManager(path.Name())
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	_ = fio.Close()
+
+	mmapIO, err = NewMMapIOManager(path.Name())
+	assert.Nil(t, err)
+	size, err = mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	b := make([]byte, 5)
+	n, err := mmapIO.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), b)
+
+	_, err = mmapIO.Write([]byte("key-c"))
+	assert.Equal(t, ErrMMapWriteNotSupported, err)
+
+	err = mmapIO.Close()
+	assert.Nil(t, err)
+}
+
+func TestMMap_Truncate(t *testing.T) {
+	path, _ := os.CreateTemp("", "mmap-a.data")
+	defer os.Remove(path.Name())
+	_, _ = path.Write([]byte("key-akey-b"))
+	_ = path.Close()
+
+	mmapIO, err := NewMMapIOManager(path.Name())
+	assert.Nil(t, err)
+	err = mmapIO.Truncate(5)
+	assert.Nil(t, err)
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+
+	b := make([]byte, 5)
+	_, err = mmapIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+	_ = mmapIO.Close()
+
+	stat, err := os.Stat(path.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), stat.Size())
+}
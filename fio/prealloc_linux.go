@@ -0,0 +1,23 @@
+//go:build linux
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate 为文件分配磁盘空间，文件大小会扩展到 size，新增的部分填充为 0
+func fallocate(fd *os.File, size int64) error {
+	err := syscall.Fallocate(int(fd.Fd()), 0, 0, size)
+	// 文件系统不支持时退化为扩展文件大小
+	if err == syscall.EOPNOTSUPP {
+		return fd.Truncate(size)
+	}
+	return err
+}
+
+// datasync 只持久化文件数据，不持久化修改时间等元数据
+func datasync(fd *os.File) error {
+	return syscall.Fdatasync(int(fd.Fd()))
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// fallocate 不支持预分配空间的系统上只扩展文件大小
+func fallocate(fd *os.File, size int64) error {
+	return fd.Truncate(size)
+}
+
+func datasync(fd *os.File) error {
+	return fd.Sync()
+}
@@ -0,0 +1,11 @@
+package fio
+
+import "syscall"
+
+func freeSpace(dirPath string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirPath, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
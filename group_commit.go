@@ -0,0 +1,103 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// commitRequest 等待组提交的写入请求
+type commitRequest struct {
+	key    []byte
+	record *data.LogRecord
+	err    error
+	done   chan bool // 写入完成时收到 false，成为新的 leader 时收到 true
+}
+
+// groupCommit 组提交
+// 并发的写入请求先进入等待队列，由其中一个请求作为 leader 将队列中的数据一次性写入并持久化，
+// 完成之后唤醒其他的请求，并把 leader 交给下一批请求中的第一个
+func (db *DB) groupCommit(req *commitRequest) error {
+	req.done = make(chan bool, 1)
+
+	db.commitMu.Lock()
+	db.commitQueue = append(db.commitQueue, req)
+	if db.committing {
+		db.commitMu.Unlock()
+		if leader := <-req.done; !leader {
+			return req.err
+		}
+		db.commitMu.Lock()
+	}
+	// 当前请求作为 leader 取出队列中所有的请求
+	db.committing = true
+	group := db.commitQueue
+	db.commitQueue = nil
+	db.commitMu.Unlock()
+
+	err := db.writeGroup(group)
+
+	db.commitMu.Lock()
+	if len(db.commitQueue) > 0 {
+		db.commitQueue[0].done <- true
+	} else {
+		db.committing = false
+	}
+	db.commitMu.Unlock()
+
+	for _, r := range group {
+		r.err = err
+		if r != req {
+			r.done <- false
+		}
+	}
+	return err
+}
+
+// writeGroup 将一批数据通过一次 Write 写入活跃文件，持久化之后再更新内存索引
+func (db *DB) writeGroup(group []*commitRequest) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var buf []byte
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if err := db.activeFile.Write(buf); err != nil {
+			return err
+		}
+		db.bytesWrite += uint(len(buf))
+		buf = buf[:0]
+		return nil
+	}
+
+	positions := make([]*data.LogRecordPos, len(group))
+	for i, req := range group {
+		encRecord, size := data.EncodeLogRecord(req.record)
+		// 活跃文件写满时，先写入已经缓存的数据再切换文件
+		if db.activeFile == nil || db.activeFile.WriteOff+int64(len(buf))+size > db.options.DataFileSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := db.prepareActiveFile(size); err != nil {
+				return err
+			}
+		}
+		positions[i] = &data.LogRecordPos{
+			Fid:    db.activeFile.FileId,
+			Offset: db.activeFile.WriteOff + int64(len(buf)),
+			Size:   uint32(size),
+			Expire: req.record.Expire,
+		}
+		buf = append(buf, encRecord...)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+
+	//更新内存索引
+	for i, req := range group {
+		db.updateIndex(req.key, req.record.Type, positions[i])
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 并发写入，数据文件写满时需要切换
+	value := utils.RandomValue(128)
+	var wg sync.WaitGroup
+	for g := 0; g < 64; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				err := db.Put(utils.GetTestKey(g*50+i), value)
+				assert.Nil(t, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 64*50, len(db.ListKeys()))
+	assert.True(t, getStat(t, db).DataFileNum > 1)
+	assert.Equal(t, int64(0), getStat(t, db).UnsyncedSize)
+	for i := 0; i < 64*50; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	// 重启之后数据完整
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 64*50, len(db2.ListKeys()))
+	for i := 0; i < 64*50; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func BenchmarkDB_PutSyncWrites(b *testing.B) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-bench")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(b, err)
+
+	value := utils.RandomValue(128)
+	b.ResetTimer()
+	b.SetParallelism(64)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_ = db.Put(utils.GetTestKey(i), value)
+			i++
+		}
+	})
+}
@@ -6,7 +6,8 @@ import (
 	"path/filepath"
 )
 
-const bptreeIndexFileName = "bptree-index"
+// BptreeIndexFileName B+ 树索引文件的名称
+const BptreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
@@ -14,13 +15,20 @@ type BPlusTree struct {
 	tree *bbolt.DB
 }
 
-func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
-	opts := bbolt.DefaultOptions
+func NewBPlusTree(dirPath string, syncWrites bool, readOnly bool) *BPlusTree {
+	opts := *bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	opts.ReadOnly = readOnly
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BptreeIndexFileName), 0644, &opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
+	// 只读模式下不能创建 bucket
+	if readOnly {
+		return &BPlusTree{
+			tree: bptree,
+		}
+	}
 
 	// 创建对应的bucket
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
@@ -36,14 +44,23 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 }
 
 // Put 向索引中添加key对应的数据位置信息
-func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var oldValue []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
+		oldValue = bucket.Get(key)
+		if len(oldValue) != 0 {
+			// bbolt 返回的切片只在事务内有效
+			oldValue = append([]byte(nil), oldValue...)
+		}
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
 		panic("failed to put value in bptree")
 	}
-	return true
+	if len(oldValue) == 0 {
+		return nil
+	}
+	return data.DecodeLogRecordPos(oldValue)
 }
 
 // Get 得到key对应的数据位置信息
@@ -63,19 +80,19 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 }
 
 // Delete 删除key对应的数据位置信息
-func (bpt *BPlusTree) Delete(key []byte) bool {
-	var ok bool
+func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		if value := bucket.Get(key); value != nil {
-			ok = true
+			oldPos = data.DecodeLogRecordPos(value)
 			return bucket.Delete(key)
 		}
 		return nil
 	}); err != nil {
 		panic("failed to delete value in bptree")
 	}
-	return ok
+	return oldPos, oldPos != nil
 }
 
 // Size 索引存在多少数据
@@ -15,18 +15,18 @@ func TestBPlusTree_Put(t *testing.T) {
 		_ = os.RemoveAll(path)
 	}()
 
-	tree := NewBPlusTree(path)
+	tree := NewBPlusTree(path, false, false)
 
 	res1 := tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 11})
-	assert.NotNil(t, res1)
+	assert.Nil(t, res1)
 
 	res2 := tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 12})
-	assert.NotNil(t, res2)
+	assert.Nil(t, res2)
 	res3 := tree.Put([]byte("acc"), &data.LogRecordPos{Fid: 1, Offset: 13})
-	assert.NotNil(t, res3)
+	assert.Nil(t, res3)
 
 	res4 := tree.Put([]byte("acc"), &data.LogRecordPos{Fid: 1, Offset: 15})
-	assert.NotNil(t, res4)
+	assert.Equal(t, int64(13), res4.Offset)
 
 }
 
@@ -36,7 +36,7 @@ func TestBPlusTree_Get(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree := NewBPlusTree(path, false, false)
 
 	pos := tree.Get([]byte("not exist"))
 	assert.Nil(t, pos)
@@ -57,14 +57,16 @@ func TestBPlusTree_Delete(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree := NewBPlusTree(path, false, false)
 
-	res1 := tree.Delete([]byte("not exist"))
-	assert.False(t, res1)
+	res1, ok1 := tree.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
 
 	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
-	res2 := tree.Delete([]byte("aac"))
-	assert.True(t, res2)
+	res2, ok2 := tree.Delete([]byte("aac"))
+	assert.True(t, ok2)
+	assert.Equal(t, uint32(123), res2.Fid)
 
 	pos2 := tree.Get([]byte("aac"))
 	assert.Nil(t, pos2)
@@ -78,16 +80,16 @@ func TestBPlusTree_Size(t *testing.T) {
 		_ = os.RemoveAll(path)
 
 	}()
-	tree := NewBPlusTree(path)
+	tree := NewBPlusTree(path, false, false)
 
 	assert.Equal(t, 0, tree.Size())
 	res1 := tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 11})
-	assert.NotNil(t, res1)
+	assert.Nil(t, res1)
 
 	res2 := tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 12})
-	assert.NotNil(t, res2)
+	assert.Nil(t, res2)
 	res3 := tree.Put([]byte("acc"), &data.LogRecordPos{Fid: 1, Offset: 13})
-	assert.NotNil(t, res3)
+	assert.Nil(t, res3)
 
 	assert.Equal(t, 3, tree.Size())
 
@@ -101,7 +103,7 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		_ = os.RemoveAll(path)
 
 	}()
-	tree := NewBPlusTree(path)
+	tree := NewBPlusTree(path, false, false)
 
 	tree.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 12})
 	tree.Put([]byte("adse"), &data.LogRecordPos{Fid: 1, Offset: 12})
@@ -11,11 +11,14 @@ func TestBtree_Put(t *testing.T) {
 	bt := NewBTree()
 
 	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
-	assert.True(t, res1)
+	assert.Nil(t, res1)
 
 	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
-	assert.True(t, res2)
+	assert.Nil(t, res2)
 
+	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 11, Offset: 12})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(2), res3.Offset)
 }
 
 func TestBtree_Get(t *testing.T) {
@@ -23,16 +26,16 @@ func TestBtree_Get(t *testing.T) {
 	bt := NewBTree()
 
 	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
-	assert.True(t, res1)
+	assert.Nil(t, res1)
 
 	pos1 := bt.Get(nil)
 	assert.Equal(t, uint32(1), pos1.Fid)
 	assert.Equal(t, int64(100), pos1.Offset)
 
 	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
-	assert.True(t, res2)
+	assert.Nil(t, res2)
 	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
-	assert.True(t, res3)
+	assert.NotNil(t, res3)
 
 	pos2 := bt.Get([]byte("a"))
 	assert.Equal(t, uint32(1), pos2.Fid)
@@ -44,16 +47,18 @@ func TestBtree_Delete(t *testing.T) {
 	bt := NewBTree()
 
 	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
-	assert.True(t, res1)
-	res2 := bt.Delete(nil)
-	assert.True(t, res2)
+	assert.Nil(t, res1)
+	res2, ok1 := bt.Delete(nil)
+	assert.True(t, ok1)
+	assert.Equal(t, int64(100), res2.Offset)
 
 	res3 := bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 33})
-	assert.True(t, res3)
+	assert.Nil(t, res3)
 	res5 := bt.Get([]byte("aaa"))
 	t.Log(res5)
-	res4 := bt.Delete([]byte("aaa"))
-	assert.True(t, res4)
+	res4, ok2 := bt.Delete([]byte("aaa"))
+	assert.True(t, ok2)
+	assert.Equal(t, uint32(22), res4.Fid)
 	res6 := bt.Get([]byte("aaa"))
 	t.Log(res6)
 }
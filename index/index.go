@@ -9,14 +9,14 @@ import (
 // Indexer 抽象的索引接口，如果后续需要接入其他的数据结构，直接实现这个接口就可以了
 // 在内存中，根据key找到存放的日志的位置信息
 type Indexer interface {
-	// Put 向索引中添加key对应的数据位置信息
-	Put(key []byte, pos *data.LogRecordPos) bool
+	// Put 向索引中添加key对应的数据位置信息，返回被覆盖的旧位置信息
+	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 
 	// Get 得到key对应的数据位置信息
 	Get(key []byte) *data.LogRecordPos
 
-	// Delete 删除key对应的数据位置信息
-	Delete(key []byte) bool
+	// Delete 删除key对应的数据位置信息，返回被删除的旧位置信息
+	Delete(key []byte) (*data.LogRecordPos, bool)
 
 	// Size 索引存在多少数据
 	Size() int
@@ -40,7 +40,7 @@ const (
 	BPTree
 )
 
-func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
+func NewIndexer(typ IndexType, dirPath string, sync bool, readOnly bool) Indexer {
 	switch typ {
 	case Btree:
 
@@ -49,7 +49,7 @@ func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
 
 		return NewART()
 	case BPTree:
-		return NewBPlusTree(dirPath, sync)
+		return NewBPlusTree(dirPath, sync, readOnly)
 	default:
 		panic("invalid indexer")
 	}
@@ -3,23 +3,35 @@ package bitcask_go
 import (
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
-// Iterator 迭代器
+// Iterator 迭代器，使用完之后需要调用 Close 关闭
 type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
 	options   IteratorOptions
+	epoch     uint64 //创建迭代器时的 merge 版本，索引迭代器中的位置信息都属于这个版本
 }
 
 // 初始化迭代器
 func (db *DB) NewIterator(options IteratorOptions) *Iterator {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	indexIter := db.index.Iterator(options.Reverse)
-	return &Iterator{
+	return db.registerIterator(indexIter, options, db.mergeEpoch)
+}
+
+// registerIterator 创建迭代器并记录下来，在关闭之前保留它可能读取的旧数据文件，在访问此方法之前必须持有互斥锁
+func (db *DB) registerIterator(indexIter index.Iterator, options IteratorOptions, epoch uint64) *Iterator {
+	it := &Iterator{
 		db:        db,
 		indexIter: indexIter,
 		options:   options,
+		epoch:     epoch,
 	}
+	db.iterators[it] = struct{}{}
+	return it
 }
 
 // Rewind 重新回到迭代器的起点，也就是第一个数据
@@ -37,6 +49,7 @@ func (it *Iterator) Seek(key []byte) {
 // Next 跳转到下一个 key
 func (it *Iterator) Next() {
 	it.indexIter.Next()
+	it.skipToNext()
 }
 
 // Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
@@ -54,22 +67,28 @@ func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(logRecordPos)
+	return it.db.getValueByPosition(logRecordPos, it.epoch)
 }
 
 // Close 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
+	it.db.mu.Lock()
+	delete(it.db.iterators, it)
+	it.db.releaseRetiredFiles()
+	it.db.mu.Unlock()
 	it.indexIter.Close()
 }
 
+// skipToNext 跳过前缀不匹配以及已经过期的 key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
+	now := time.Now().UnixNano()
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired(now) {
+			continue
+		}
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if prefixLen == 0 || (prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0) {
 			break
 		}
 	}
@@ -2,12 +2,18 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"context"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -15,32 +21,73 @@ const (
 	mergeFinishedKey = "merge.finished"
 )
 
+// MergeProgress merge 的进度
+type MergeProgress struct {
+	FilesTotal     int     // 需要 merge 的数据文件数量
+	FilesProcessed int     // 已经处理完的数据文件数量
+	BytesRead      int64   // 已经读取的数据量，字节为单位
+	BytesWritten   int64   // 已经重写的有效数据量，字节为单位
+	LiveRatio      float64 // 已读取的数据中有效数据的比例
+}
+
+// update 处理完一个数据文件之后更新进度并回调
+func (p *MergeProgress) update(opts MergeOptions) {
+	p.FilesProcessed++
+	if p.BytesRead > 0 {
+		p.LiveRatio = float64(p.BytesWritten) / float64(p.BytesRead)
+	}
+	if opts.Progress != nil {
+		opts.Progress(*p)
+	}
+}
+
 // Merge 清理无效数据，生成hint文件
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background(), DefaultMergeOptions)
+}
+
+// MergeContext 和 Merge 相同，每处理一条数据之前检查 ctx 是否已经取消，取消时删除 merge 目录并返回 ctx 的错误
+// 已经开始安装 merge 的结果之后不会再被取消
+func (db *DB) MergeContext(ctx context.Context, opts MergeOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
 	if db.activeFile == nil {
+		db.mu.Unlock()
 		return nil
 	}
-	db.mu.Lock()
 	// 如果merge正在进行中，直接返回
 	if db.isMerging {
 		db.mu.Unlock()
 		return ErrMergeIsProgress
 	}
-	db.isMerging = true
-	defer func() {
-		db.isMerging = false
-	}()
-
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	if db.options.IncrementalMergeFiles > 0 {
+		return db.mergeIncrementally(ctx, opts)
+	}
+	// 在线安装中途失败时数据目录中的文件已经部分替换，只能在下次启动时继续完成，不能删除
+	if db.options.MergeInstallOnline && db.hasFinishedMerge() {
+		db.mu.Unlock()
+		return ErrMergeNotInstalled
+	}
+	// 可以回收的数据量没有达到阈值
+	if db.mergeRatio() < db.options.DataFileMergeRatio {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+	// 磁盘上的可用空间放不下 merge 之后的数据
+	if err := db.checkMergeSpace(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
+	db.isMerging = true
+	defer db.finishMerging()
 
-	//将当前文件转换为旧的数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	//打开新的活跃文件
-	if err := db.activeFile.Close(); err != nil {
+	// 持久化当前活跃文件，并转换为旧的数据文件，打开新的活跃文件
+	if err := db.rotateActiveFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
@@ -62,72 +109,118 @@ func (db *DB) Merge() error {
 
 	mergePath := db.getMergePath()
 	//如果目录存在，说明发生过merge，删除
-	if _, err := os.Stat(mergePath); err == nil {
-		if err := os.RemoveAll(mergePath); err != nil {
+	if _, err := db.fs.Stat(mergePath); err == nil {
+		if err := db.fs.RemoveAll(mergePath); err != nil {
 			return err
 		}
 	}
 	// 新建一个merge path的目录
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+	if err := db.fs.MkdirAll(mergePath); err != nil {
 		return err
 	}
+	// 被取消时删除没有完成的merge目录，在关闭临时实例和hint文件之后执行
+	var cancelled bool
+	defer func() {
+		if cancelled {
+			_ = db.fs.RemoveAll(mergePath)
+		}
+	}()
 	// 打开一个新的临时bitcask实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	mergeOptions.BytesPerSync = 0
+	mergeOptions.SyncInterval = 0
+	mergeOptions.MergeCheckInterval = 0
+	mergeOptions.BlockCacheSize = 0
+	mergeOptions.ValueCacheSize = 0
+	mergeOptions.FS = db.fs
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	// 关闭临时实例，释放merge目录上的文件锁
+	var mergeDBClosed bool
+	defer func() {
+		if !mergeDBClosed {
+			_ = mergeDB.Close()
+		}
+	}()
 
 	// 打开hint文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(db.fs, mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
 	// 遍历处理每个数据文件
+	now := time.Now().UnixNano()
+	var expiredKeys [][]byte
+	progress := MergeProgress{FilesTotal: len(mergeFiles)}
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		scanner, err := data.NewRecordScanner(dataFile, dataFile.HeaderSize)
+		if err != nil {
+			return err
+		}
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err := ctx.Err(); err != nil {
+				cancelled = true
+				return err
+			}
+			logRecord, offset, size, err := scanner.Next()
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
 				return err
 			}
+			progress.BytesRead += size
 			//解析拿到的实际的Key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
-			// 内存中的数据索引位置进行比较，如果有效则重写
+			// 内存中的数据索引位置进行比较，如果有效且没有过期则重写
 			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
+				// 过期的数据不再重写，在线安装时需要从索引中删除
+				if logRecordPos.IsExpired(now) {
+					if db.options.MergeInstallOnline {
+						expiredKeys = append(expiredKeys, realKey)
+					}
+					continue
+				}
 				//  清楚事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
 					return err
 				}
+				progress.BytesWritten += int64(pos.Size)
 				// 将当前位置索引写到Hint 文件中
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
 					return err
 				}
 			}
-			// 增加offse
-			offset += size
 		}
+		progress.update(opts)
 	}
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
+	// 没有有效数据时也生成一个空的数据文件，安装时根据 id 最大的新文件判断哪些旧文件需要删除
+	if mergeDB.activeFile == nil {
+		if err := mergeDB.setActiveDataFile(); err != nil {
+			return err
+		}
+	}
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
 
 	// 写标识merge完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	defer mergeFinishedFile.Close()
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
@@ -139,9 +232,284 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
+	if !db.options.MergeInstallOnline {
+		return nil
+	}
+
+	// 关闭临时实例之后再移动其中的文件
+	mergeDBClosed = true
+	if err := mergeDB.Close(); err != nil {
+		return err
+	}
+	return db.installMerge(mergePath, nonMergeFileId, expiredKeys)
+}
+
+// finishMerging 清除 merge 正在进行的标记
+func (db *DB) finishMerging() {
+	db.mu.Lock()
+	db.isMerging = false
+	db.mu.Unlock()
+}
+
+// retiredFiles 被在线 merge 替换的旧数据文件
+// 在 merge 之前创建的快照和迭代器中的位置信息仍然指向这些文件，等它们都释放之后再关闭
+type retiredFiles struct {
+	epoch uint64                    // 替换之后的 merge 版本
+	files map[uint32]*data.DataFile // 被替换的数据文件
+}
+
+// installMerge 在线安装 merge 的结果，用 merge 生成的数据文件替换旧的数据文件，并根据 hint 文件更新索引
+// 移动文件、打开新文件和读取 hint 文件时不持有互斥锁，读写照常进行，已经打开的旧文件不受同名文件被替换的影响
+// 只有替换内存中的数据文件和更新索引时持有互斥锁，标识 merge 完成的文件最后移动，中途失败时重启会继续完成
+func (db *DB) installMerge(mergePath string, nonMergeFileId uint32, expiredKeys [][]byte) error {
+	db.mergeInstallMu.Lock()
+	defer db.mergeInstallMu.Unlock()
+
+	mergedFileIds, err := db.moveMergeFiles(mergePath, nonMergeFileId)
+	if err != nil {
+		return err
+	}
+
+	// 打开 merge 生成的数据文件
+	mergedFiles := make(map[uint32]*data.DataFile)
+	closeMergedFiles := func() {
+		for _, file := range mergedFiles {
+			_ = file.Close()
+		}
+	}
+	for _, fid := range mergedFileIds {
+		dataFile, err := db.openDataFile(fid, db.dataFileIOType())
+		if err == nil {
+			dataFile.WriteOff, err = dataFile.IoManager.Size()
+		}
+		if err != nil {
+			closeMergedFiles()
+			return err
+		}
+		mergedFiles[fid] = dataFile
+	}
+	// 读取 hint 文件中 merge 之后的位置
+	hintFile, err := data.OpenHintFile(db.fs, db.options.DirPath, fio.StandardFIO)
+	if err != nil {
+		closeMergedFiles()
+		return err
+	}
+	hintRecords, err := readHintRecords(hintFile)
+	_ = hintFile.Close()
+	if err != nil {
+		closeMergedFiles()
+		return err
+	}
+
+	db.mu.Lock()
+	// 替换旧的数据文件，旧文件在快照和迭代器都释放之后再关闭
+	oldFiles := make(map[uint32]*data.DataFile)
+	for fid, file := range db.olderFiles {
+		if fid < nonMergeFileId {
+			oldFiles[fid] = file
+			delete(db.olderFiles, fid)
+		}
+	}
+	for fid, file := range mergedFiles {
+		db.olderFiles[fid] = file
+	}
+	db.retireFiles(oldFiles)
+	// 将索引指向 merge 之后的位置
+	db.moveIndexByHint(hintRecords, oldFiles, expiredKeys)
+	// 数据文件 id 被重新使用，缓存中旧文件的数据不能再使用
+	if db.valueCache != nil {
+		db.valueCache.Clear()
+	}
+	db.releaseRetiredFiles()
+	db.mu.Unlock()
+
+	// 索引更新之后才移动标识 merge 完成的文件，B+ 树索引没有更新完时重启会重新更新
+	return db.finishMergeFiles(mergePath)
+}
+
+// retireFiles 将被 merge 替换的旧数据文件保留给之前创建的快照和迭代器，并清除它们的无效数据统计，在访问此方法之前必须持有互斥锁
+func (db *DB) retireFiles(files map[uint32]*data.DataFile) {
+	db.mergeEpoch++
+	db.retiredFiles = append(db.retiredFiles, &retiredFiles{
+		epoch: db.mergeEpoch,
+		files: files,
+	})
+	var reclaimSize int64
+	for fid, garbage := range db.fileGarbage {
+		if _, ok := files[fid]; ok {
+			delete(db.fileGarbage, fid)
+			continue
+		}
+		reclaimSize += garbage
+	}
+	atomic.StoreInt64(&db.reclaimSize, reclaimSize)
+}
+
+// hintRecord hint 文件中的一条记录，key 在 merge 之后的位置
+type hintRecord struct {
+	key     []byte
+	pos     *data.LogRecordPos
+	deleted bool // 增量 merge 保留下来的删除标记
+}
+
+// readHintRecords 读取 hint 文件中的所有记录
+func readHintRecords(hintFile *data.DataFile) ([]hintRecord, error) {
+	scanner, err := data.NewRecordScanner(hintFile, hintFile.HeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	var records []hintRecord
+	for {
+		logRecord, _, _, err := scanner.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		records = append(records, hintRecord{
+			key:     logRecord.Key,
+			pos:     data.DecodeLogRecordPos(logRecord.Value),
+			deleted: logRecord.Type == data.LogRecordDeleted,
+		})
+	}
+	return records, nil
+}
+
+// moveIndexByHint 根据 hint 文件中的记录将索引从被替换的旧文件移动到 merge 之后的位置，在访问此方法之前必须持有互斥锁
+func (db *DB) moveIndexByHint(records []hintRecord, retired map[uint32]*data.DataFile, expiredKeys [][]byte) {
+	for _, record := range records {
+		// 增量 merge 保留下来的删除标记只能由全量 merge 回收，不计入可回收的数据，避免同一个文件被反复选中
+		if record.deleted {
+			continue
+		}
+		db.moveIndex(record.key, record.pos, retired)
+	}
+	// merge 时已经过期的数据没有重写，从索引中删除
+	for _, key := range expiredKeys {
+		db.moveIndex(key, nil, retired)
+	}
+}
+
+// moveIndex 将 key 的索引从被 merge 的文件移动到 pos，pos 为 nil 表示删除，在访问此方法之前必须持有互斥锁
+func (db *DB) moveIndex(key []byte, pos *data.LogRecordPos, retired map[uint32]*data.DataFile) {
+	oldPos := db.index.Get(key)
+	// merge 开始之后 key 被修改或删除过，以新的数据为准
+	if oldPos == nil {
+		return
+	}
+	if _, ok := retired[oldPos.Fid]; !ok {
+		return
+	}
+	if pos == nil {
+		db.index.Delete(key)
+	} else {
+		db.index.Put(key, pos)
+	}
+	// 活跃的快照需要保留移动之前的位置
+	for snap := range db.snapshots {
+		snap.saveMovedPos(key, oldPos, pos)
+	}
+}
+
+// releaseRetiredFiles 关闭已经没有快照和迭代器使用的旧数据文件，在访问此方法之前必须持有互斥锁
+func (db *DB) releaseRetiredFiles() {
+	if len(db.retiredFiles) == 0 {
+		return
+	}
+	minEpoch := db.mergeEpoch
+	for snap := range db.snapshots {
+		if snap.epoch < minEpoch {
+			minEpoch = snap.epoch
+		}
+	}
+	for it := range db.iterators {
+		if it.epoch < minEpoch {
+			minEpoch = it.epoch
+		}
+	}
+	var remain []*retiredFiles
+	for _, retired := range db.retiredFiles {
+		if retired.epoch > minEpoch {
+			remain = append(remain, retired)
+			continue
+		}
+		for _, file := range retired.files {
+			_ = file.Close()
+		}
+	}
+	db.retiredFiles = remain
+}
+
+// dataFilesSize 所有数据文件中数据的总大小，在访问此方法之前必须持有互斥锁
+func (db *DB) dataFilesSize() int64 {
+	totalSize := db.activeFile.WriteOff
+	for _, file := range db.olderFiles {
+		totalSize += file.WriteOff
+	}
+	return totalSize
+}
+
+// mergeRatio 可以回收的数据量占数据文件总大小的比例，在访问此方法之前必须持有互斥锁
+func (db *DB) mergeRatio() float32 {
+	totalSize := db.dataFilesSize()
+	if totalSize == 0 {
+		return 0
+	}
+	return float32(atomic.LoadInt64(&db.reclaimSize)) / float32(totalSize)
+}
+
+// checkMergeSpace 检查磁盘空间是否能放下 merge 之后的有效数据以及预留的空间，在访问此方法之前必须持有互斥锁
+func (db *DB) checkMergeSpace() error {
+	liveSize := db.dataFilesSize() - atomic.LoadInt64(&db.reclaimSize)
+	if liveSize < 0 {
+		liveSize = 0
+	}
+	freeSpace, err := db.fs.FreeSpace(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if liveSize > freeSpace-db.options.MergeFreeSpaceMargin {
+		return ErrNoEnoughSpaceForMerge
+	}
 	return nil
 }
 
+// mergePeriodically 按照 MergeCheckInterval 定时检查，达到阈值时自动 merge
+func (db *DB) mergePeriodically() {
+	defer db.mergeWg.Done()
+	ticker := time.NewTicker(db.options.MergeCheckInterval)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-db.mergeClose:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt64(&db.reclaimSize) == 0 || db.hasFinishedMerge() {
+				continue
+			}
+			// 没有达到阈值或者出错时等待下次检查，关闭数据库时取消正在进行的 merge
+			_ = db.MergeContext(ctx, DefaultMergeOptions)
+		case <-db.mergeClose:
+			return
+		}
+	}
+}
+
+// hasFinishedMerge 是否有已经完成但还没有加载的 merge 结果，merge 的结果在下次启动时才会生效
+func (db *DB) hasFinishedMerge() bool {
+	mergeFinFileName := filepath.Join(db.getMergePath(), data.MergeFinishedFileName)
+	_, err := db.fs.Stat(mergeFinFileName)
+	return err == nil
+}
+
 func (db *DB) getMergePath() string {
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	base := path.Base(db.options.DirPath)
@@ -150,64 +518,166 @@ func (db *DB) getMergePath() string {
 
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
-	defer func() {
-		_ = os.Remove(mergePath)
-	}()
-	dirEntries, err := os.ReadDir(mergePath)
+	dirEntries, err := db.fs.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
 
 	// 查找标识merge完成的文件，判断merge是否处理完了
 	var mergeFinisher bool
-	var mergeFileNames []string
 	for _, entry := range dirEntries {
 		if entry.Name() == data.MergeFinishedFileName {
 			mergeFinisher = true
 		}
-		if entry.Name() == data.SeqNoFileName {
-			continue
-		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
+	// 没有完成的 merge 直接删除
 	if !mergeFinisher {
+		_ = db.fs.RemoveAll(mergePath)
 		return nil
 	}
 	nonMergeFileId, err := db.getNonMergeFileID(mergePath)
 	if err != nil {
 		return err
 	}
+	// 移动失败时保留 merge 目录，下次启动继续完成
+	if _, err := db.moveMergeFiles(mergePath, nonMergeFileId); err != nil {
+		return err
+	}
+	// B+ 树索引保存在磁盘上，需要根据 hint 文件更新为 merge 之后的位置
+	if db.options.IndexType == BPlusTree {
+		if err := db.rewriteIndexByHint(nonMergeFileId); err != nil {
+			return err
+		}
+	}
+	return db.finishMergeFiles(mergePath)
+}
 
-	//删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+// finishMergeFiles 所有文件移动完成并且索引已经更新之后，移动标识 merge 完成的文件并删除 merge 目录
+func (db *DB) finishMergeFiles(mergePath string) error {
+	srcPath := filepath.Join(mergePath, data.MergeFinishedFileName)
+	destPath := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if err := db.fs.Rename(srcPath, destPath); err != nil {
+		return err
+	}
+	_ = db.fs.RemoveAll(mergePath)
+	return nil
+}
+
+// rewriteIndexByHint 根据 hint 文件将 B+ 树索引中被 merge 的文件中的位置改为 merge 之后的位置
+// hint 文件中没有的 key 在 merge 时已经过期，从索引中删除
+// 每个 key 更新之后的位置只取决于 hint 文件，中途失败时重新执行的结果相同
+func (db *DB) rewriteIndexByHint(nonMergeFileId uint32) error {
+	hintFile, err := data.OpenHintFile(db.fs, db.options.DirPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	records, err := readHintRecords(hintFile)
+	if err != nil {
+		return err
+	}
+	hintPos := make(map[string]*data.LogRecordPos, len(records))
+	for _, record := range records {
+		hintPos[string(record.key)] = record.pos
+	}
+
+	// 先找出需要更新的 key，遍历结束之后再修改索引
+	var keys [][]byte
+	it := db.index.Iterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		if it.Value().Fid < nonMergeFileId {
+			keys = append(keys, append([]byte(nil), it.Key()...))
+		}
+	}
+	it.Close()
+	for _, key := range keys {
+		if pos, ok := hintPos[string(key)]; ok {
+			db.index.Put(key, pos)
+		} else {
+			db.index.Delete(key)
+		}
+	}
+	return nil
+}
+
+// moveMergeFiles 将 merge 目录中的文件移动到数据目录中，替换已经被 merge 的旧数据文件，返回移动过来的数据文件 id
+// merge 生成的数据文件 id 从 0 开始连续分配，并且至少有一个，每个新文件直接覆盖相同 id 的旧文件
+// 只有没有对应新文件的旧文件在移动之前删除，数据文件按 id 从小到大移动，标识 merge 完成的文件留在 merge 目录中
+// 中途失败时 merge 目录中仍然保留 id 最大的新文件和标识 merge 完成的文件，下次启动可以据此继续完成
+func (db *DB) moveMergeFiles(mergePath string, nonMergeFileId uint32) ([]uint32, error) {
+	dirEntries, err := db.fs.ReadDir(mergePath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []uint32
+	var otherFileNames []string
+	for _, entry := range dirEntries {
+		// 临时实例的事务序列号、可回收数据量的统计、B+ 树索引和文件锁不需要移动
+		switch entry.Name() {
+		case data.SeqNoFileName, data.SeqNoTempFileName, data.GarbageFileName, data.GarbageTempFileName,
+			index.BptreeIndexFileName, fileLockName, data.MergeFinishedFileName:
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			fid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+			if err != nil {
+				return nil, ErrDataDirectoryCorrupted
 			}
+			fileIds = append(fileIds, uint32(fid))
+			continue
 		}
+		otherFileNames = append(otherFileNames, entry.Name())
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	//旧的数据文件被替换，保存的可回收数据量不再准确，启动时重新统计
+	garbageFileName := filepath.Join(db.options.DirPath, data.GarbageFileName)
+	if err := db.fs.Remove(garbageFileName); err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
-	//将新的数据文件移动到到数据目录中‘
+	//删除增量 merge 生成的 hint 文件，以及没有对应新文件的旧数据文件
+	//merge 目录中已经没有数据文件时，说明上次已经删除过，数据目录中的文件都是新的
+	for fileId := uint32(0); fileId < nonMergeFileId; fileId++ {
+		fileNames := []string{data.GetDataHintFileName(db.options.DirPath, fileId)}
+		if len(fileIds) > 0 && fileId > fileIds[len(fileIds)-1] {
+			fileNames = append(fileNames, data.GetDataFileName(db.options.DirPath, fileId))
+		}
+		for _, fileName := range fileNames {
+			if _, err := db.fs.Stat(fileName); err == nil {
+				if err := db.fs.Remove(fileName); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	//将新的数据文件移动到到数据目录中，覆盖相同 id 的旧文件
+	var mergeFileNames []string
+	for _, fid := range fileIds {
+		mergeFileNames = append(mergeFileNames, filepath.Base(data.GetDataFileName(mergePath, fid)))
+	}
+	mergeFileNames = append(mergeFileNames, otherFileNames...)
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
-			return err
+		if err := db.fs.Rename(srcPath, destPath); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	return fileIds, nil
 }
 
 func (db *DB) getNonMergeFileID(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, dirPath, db.fileIOType())
 	if err != nil {
 		return 0, err
 	}
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	defer mergeFinishedFile.Close()
+	record, _, err := mergeFinishedFile.ReadLogRecord(mergeFinishedFile.HeaderSize)
 	if err != nil {
 		return 0, err
 	}
@@ -221,19 +691,45 @@ func (db *DB) getNonMergeFileID(dirPath string) (uint32, error) {
 func (db *DB) loadIndexFromHintFile() error {
 	//查看hint索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 	// 打开hint
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	ioType := db.fileIOType()
+	if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
+	}
+	hintFile, err := data.OpenHintFile(db.fs, db.options.DirPath, ioType)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+
+	// 增量 merge 先写好新文件的 hint 文件再删除被 merge 的数据文件，id 最大的 hint 文件之前的数据文件可能已经被删除
+	var hintFileId uint32
+	var hasHintFile bool
+	fileIds := make([]uint32, 0, len(db.olderFiles)+1)
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	if db.activeFile != nil {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	for _, fid := range fileIds {
+		if _, err := db.fs.Stat(data.GetDataHintFileName(db.options.DirPath, fid)); err == nil {
+			if !hasHintFile || fid > hintFileId {
+				hintFileId, hasHintFile = fid, true
+			}
+		}
+	}
 
 	//读取文件中的索引
-	var offset int64 = 0
+	scanner, err := data.NewRecordScanner(hintFile, hintFile.HeaderSize)
+	if err != nil {
+		return err
+	}
 	for {
-		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		logRecord, _, _, err := scanner.Next()
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -241,8 +737,14 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if db.getDataFileById(pos.Fid) == nil {
+			// 数据文件被增量 merge 删除，其中的有效数据在之后的文件中，否则数据文件丢失
+			if hasHintFile && pos.Fid < hintFileId {
+				continue
+			}
+			return ErrDataFileNotFound
+		}
 		db.index.Put(logRecord.Key, pos)
-		offset += size
 	}
 	return nil
 }
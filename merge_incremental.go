@@ -0,0 +1,373 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// mergeIncrementally 增量 merge，只处理无效数据最多的 IncrementalMergeFiles 个数据文件
+// 有效数据重写到新的数据文件中，写满 DataFileSize 之后换到下一个文件，同时生成每个文件对应的 hint 文件，完成之后在线替换掉原来的文件
+// 新文件的 id 在 merge 开始时预留，比被 merge 的文件都大，比之后写入的文件都小，重启时按照 id 的顺序加载仍然是正确的
+// 调用之前必须持有互斥锁，返回之前会释放
+func (db *DB) mergeIncrementally(ctx context.Context, opts MergeOptions) error {
+	// 已经完成的全量 merge 在下次启动时才会安装，merge 目录不能被删除
+	if db.hasFinishedMerge() {
+		db.mu.Unlock()
+		return ErrMergeNotInstalled
+	}
+	mergeFiles := db.pickMergeFiles()
+	if len(mergeFiles) == 0 {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+	// 磁盘上的可用空间放不下重写的有效数据
+	var liveSize int64
+	for _, file := range mergeFiles {
+		liveSize += file.WriteOff - db.fileGarbage[file.FileId]
+	}
+	freeSpace, err := db.fs.FreeSpace(db.options.DirPath)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if liveSize > freeSpace-db.options.MergeFreeSpaceMargin {
+		db.mu.Unlock()
+		return ErrNoEnoughSpaceForMerge
+	}
+	db.isMerging = true
+	defer db.finishMerging()
+
+	// 持久化当前活跃文件，并跳过和被 merge 的文件数量相同的文件 id 留给 merge 生成的文件
+	// 有效数据按原来的顺序写入，每个文件都写满之后才换到下一个，需要的文件不会比被 merge 的文件更多
+	firstOutputFileId := db.activeFile.FileId + 1
+	maxOutputFileId := firstOutputFileId + uint32(len(mergeFiles)) - 1
+	if err := db.rotateActiveFileTo(maxOutputFileId + 1); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	// 比被 merge 的文件 id 更小的文件中可能还有被删除的 key 的旧数据，这些 key 的删除标记需要保留
+	var minFileId = firstOutputFileId
+	for fid := range db.olderFiles {
+		if _, ok := mergeFiles[fid]; !ok && fid < minFileId {
+			minFileId = fid
+		}
+	}
+	db.mu.Unlock()
+
+	fileIds := make([]uint32, 0, len(mergeFiles))
+	for fid := range mergeFiles {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	mergePath := db.getMergePath()
+	if _, err := db.fs.Stat(mergePath); err == nil {
+		if err := db.fs.RemoveAll(mergePath); err != nil {
+			return err
+		}
+	}
+	if err := db.fs.MkdirAll(mergePath); err != nil {
+		return err
+	}
+	var cancelled bool
+	defer func() {
+		if cancelled {
+			_ = db.fs.RemoveAll(mergePath)
+		}
+	}()
+	// 当前写入的新文件和对应的 hint 文件
+	var outputFileIds []uint32
+	var outputFile, hintFile *data.DataFile
+	var openedFiles []*data.DataFile
+	defer func() {
+		for _, file := range openedFiles {
+			_ = file.Close()
+		}
+	}()
+	openOutputFile := func(fid uint32) error {
+		dataFile, err := data.OpenDataFile(db.fs, mergePath, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		openedFiles = append(openedFiles, dataFile)
+		dataHintFile, err := data.OpenDataHintFile(db.fs, mergePath, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		openedFiles = append(openedFiles, dataHintFile)
+		outputFileIds = append(outputFileIds, fid)
+		outputFile, hintFile = dataFile, dataHintFile
+		return nil
+	}
+	if err := openOutputFile(firstOutputFileId); err != nil {
+		return err
+	}
+
+	progress := MergeProgress{FilesTotal: len(fileIds)}
+	write := func(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		encRecord, size := data.EncodeLogRecord(logRecord)
+		// 写满之后换到下一个预留的文件，被 merge 的文件本身超过 DataFileSize 时预留的 id 可能不够，剩下的数据写在最后一个文件中
+		if outputFile.WriteOff > outputFile.HeaderSize && outputFile.WriteOff+size > db.options.DataFileSize &&
+			outputFile.FileId < maxOutputFileId {
+			if err := openOutputFile(outputFile.FileId + 1); err != nil {
+				return nil, err
+			}
+		}
+		pos := &data.LogRecordPos{Fid: outputFile.FileId, Offset: outputFile.WriteOff, Size: uint32(size), Expire: logRecord.Expire}
+		if err := outputFile.Write(encRecord); err != nil {
+			return nil, err
+		}
+		progress.BytesWritten += size
+		return pos, nil
+	}
+
+	now := time.Now().UnixNano()
+	var expiredKeys [][]byte
+	for _, fid := range fileIds {
+		dataFile := mergeFiles[fid]
+		scanner, err := data.NewRecordScanner(dataFile, dataFile.HeaderSize)
+		if err != nil {
+			return err
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				cancelled = true
+				return err
+			}
+			logRecord, offset, size, err := scanner.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			progress.BytesRead += size
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+			switch logRecord.Type {
+			case data.LogRecordTxnFinished:
+				continue
+			case data.LogRecordDeleted:
+				// key 仍然不存在并且更早的文件中可能还有它的数据时，保留删除标记
+				if fid > minFileId && db.index.Get(realKey) == nil {
+					pos, err := write(logRecord)
+					if err != nil {
+						return err
+					}
+					if err := hintFile.WriteDeletedHintRecord(realKey, pos); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			logRecordPos := db.index.Get(realKey)
+			if logRecordPos == nil || logRecordPos.Fid != fid || logRecordPos.Offset != offset {
+				continue
+			}
+			if logRecordPos.IsExpired(now) {
+				expiredKeys = append(expiredKeys, realKey)
+				// 和删除的数据一样，更早的文件中可能还有这个 key 的旧数据，需要写入删除标记
+				if fid > minFileId {
+					pos, err := write(&data.LogRecord{Key: logRecord.Key, Type: data.LogRecordDeleted})
+					if err != nil {
+						return err
+					}
+					if err := hintFile.WriteDeletedHintRecord(realKey, pos); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			pos, err := write(logRecord)
+			if err != nil {
+				return err
+			}
+			if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				return err
+			}
+		}
+		progress.update(opts)
+	}
+	for _, file := range openedFiles {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	return db.installIncrementalMerge(mergePath, outputFileIds, mergeFiles, expiredKeys)
+}
+
+// pickMergeFiles 选出无效数据最多的几个旧数据文件，无效数据的比例要达到 DataFileMergeRatio，在访问此方法之前必须持有互斥锁
+func (db *DB) pickMergeFiles() map[uint32]*data.DataFile {
+	var candidates []*data.DataFile
+	for fid, file := range db.olderFiles {
+		garbage := db.fileGarbage[fid]
+		dataSize := file.WriteOff - file.HeaderSize
+		if garbage <= 0 || dataSize <= 0 {
+			continue
+		}
+		if float32(garbage)/float32(dataSize) < db.options.DataFileMergeRatio {
+			continue
+		}
+		candidates = append(candidates, file)
+	}
+	// 无效数据一样多时优先选择更早的文件
+	sort.Slice(candidates, func(i, j int) bool {
+		gi, gj := db.fileGarbage[candidates[i].FileId], db.fileGarbage[candidates[j].FileId]
+		if gi != gj {
+			return gi > gj
+		}
+		return candidates[i].FileId < candidates[j].FileId
+	})
+
+	mergeFiles := make(map[uint32]*data.DataFile)
+	for _, file := range candidates {
+		if len(mergeFiles) >= db.options.IncrementalMergeFiles {
+			break
+		}
+		// 文件开头是上一个文件中没有写完的事务，事务完成的标记不能单独删除
+		record, _, err := file.ReadLogRecord(file.HeaderSize)
+		if err != nil {
+			continue
+		}
+		if _, seqNo := parseLogRecordKey(record.Key); seqNo != nonTransactionSeqNo {
+			continue
+		}
+		mergeFiles[file.FileId] = file
+	}
+	return mergeFiles
+}
+
+// installIncrementalMerge 在线安装增量 merge 的结果，将新的数据文件和 hint 文件移动到数据目录中，更新索引并删除被 merge 的文件
+// 新文件使用预留的 id，移动文件、打开新文件和读取 hint 文件时不持有互斥锁，只有替换内存中的数据文件和更新索引时持有
+// 被 merge 的文件在索引更新之后才删除，中途失败时重启仍然可以从原来的文件中加载数据
+func (db *DB) installIncrementalMerge(mergePath string, outputFileIds []uint32, mergeFiles map[uint32]*data.DataFile, expiredKeys [][]byte) error {
+	db.mergeInstallMu.Lock()
+	defer db.mergeInstallMu.Unlock()
+
+	// 每个新文件都先移动数据文件再移动 hint 文件，hint 文件存在时对应的数据文件一定是完整的
+	for _, fid := range outputFileIds {
+		fileNames := []string{
+			data.GetDataFileName(db.options.DirPath, fid),
+			data.GetDataHintFileName(db.options.DirPath, fid),
+		}
+		srcNames := []string{
+			data.GetDataFileName(mergePath, fid),
+			data.GetDataHintFileName(mergePath, fid),
+		}
+		for i := range fileNames {
+			if err := db.fs.Rename(srcNames[i], fileNames[i]); err != nil {
+				return err
+			}
+		}
+	}
+	_ = db.fs.RemoveAll(mergePath)
+
+	outputFiles := make(map[uint32]*data.DataFile)
+	closeOutputFiles := func() {
+		for _, file := range outputFiles {
+			_ = file.Close()
+		}
+	}
+	var hintRecords []hintRecord
+	for _, fid := range outputFileIds {
+		outputFile, err := db.openDataFile(fid, db.dataFileIOType())
+		if err == nil {
+			outputFiles[fid] = outputFile
+			outputFile.WriteOff, err = outputFile.IoManager.Size()
+		}
+		if err != nil {
+			closeOutputFiles()
+			return err
+		}
+		hintFile, err := data.OpenDataHintFile(db.fs, db.options.DirPath, fid, fio.StandardFIO)
+		if err != nil {
+			closeOutputFiles()
+			return err
+		}
+		records, err := readHintRecords(hintFile)
+		_ = hintFile.Close()
+		if err != nil {
+			closeOutputFiles()
+			return err
+		}
+		hintRecords = append(hintRecords, records...)
+	}
+
+	db.mu.Lock()
+	for fid, file := range outputFiles {
+		db.olderFiles[fid] = file
+	}
+	for fid := range mergeFiles {
+		delete(db.olderFiles, fid)
+	}
+	db.retireFiles(mergeFiles)
+	db.moveIndexByHint(hintRecords, mergeFiles, expiredKeys)
+	db.releaseRetiredFiles()
+	db.mu.Unlock()
+
+	// 有效数据都已经在新的文件中，删除被 merge 的文件
+	for fid := range mergeFiles {
+		fileNames := []string{
+			data.GetDataFileName(db.options.DirPath, fid),
+			data.GetDataHintFileName(db.options.DirPath, fid),
+		}
+		for _, fileName := range fileNames {
+			if err := db.fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadIndexFromDataHintFile 数据文件有对应的 hint 文件时从中加载索引，返回是否加载过
+func (db *DB) loadIndexFromDataHintFile(dataFile *data.DataFile) (bool, error) {
+	hintFileName := data.GetDataHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := db.fs.Stat(hintFileName); os.IsNotExist(err) {
+		return false, nil
+	}
+	hintFile, err := data.OpenDataHintFile(db.fs, db.options.DirPath, dataFile.FileId, db.fileIOType())
+	if err != nil {
+		return false, err
+	}
+	defer hintFile.Close()
+
+	scanner, err := data.NewRecordScanner(hintFile, hintFile.HeaderSize)
+	if err != nil {
+		return false, err
+	}
+	for {
+		logRecord, _, _, err := scanner.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return false, err
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		var oldPos *data.LogRecordPos
+		if logRecord.Type == data.LogRecordDeleted {
+			// 和在线安装时一样，保留下来的删除标记不计入可回收的数据
+			oldPos, _ = db.index.Delete(logRecord.Key)
+		} else {
+			oldPos = db.index.Put(logRecord.Key, pos)
+		}
+		if oldPos != nil {
+			db.addGarbage(oldPos.Fid, int64(oldPos.Size))
+		}
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	dataFile.WriteOff = size
+	return true, nil
+}
@@ -0,0 +1,922 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// merge 之后可以继续写入
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	// 重启之后加载 merge 的结果
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 40001, len(keys))
+
+	for i := 10000; i < 50000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	_, err = os.Stat(db2.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Merge_RatioUnreached(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-ratio")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 删除少量数据，没有达到阈值
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+
+	for i := 100; i < 800; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	opts.DataFileMergeRatio = 1.5
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_MergePeriodically(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-auto")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0.3
+	opts.MergeCheckInterval = 10 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 后台协程检查到阈值之后自动 merge
+	var merged bool
+	for i := 0; i < 200 && !merged; i++ {
+		time.Sleep(10 * time.Millisecond)
+		merged = db.hasFinishedMerge()
+	}
+	assert.True(t, merged)
+
+	fileNum := getStat(t, db).DataFileNum
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.MergeCheckInterval = 0
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	assert.True(t, getStat(t, db2).DataFileNum < fileNum)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_MergePeriodically_Concurrent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-auto-concurrent")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MergeInstallOnline = true
+	opts.MergeCheckInterval = time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := utils.RandomValue(128)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+
+	// 后台协程和手动 merge 同时进行，期间继续写入
+	for n := 0; n < 20; n++ {
+		for i := 0; i < 100; i++ {
+			err := db.Put(utils.GetTestKey(i), value)
+			assert.Nil(t, err)
+		}
+		err := db.Merge()
+		if err != nil {
+			assert.Equal(t, ErrMergeIsProgress, err)
+		}
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.MergeCheckInterval = 0
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	val, err := db2.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
+
+func TestDB_Merge_InstallOnline(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MergeInstallOnline = true
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 5000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	err = db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+	for i := 2500; i < 2600; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 之前创建的快照和迭代器
+	snap := db.NewSnapshot()
+	iter := db.NewIterator(DefaultIteratorOptions)
+	err = db.Put(utils.GetTestKey(2500), []byte("new value"))
+	assert.Nil(t, err)
+
+	stat := getStat(t, db)
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 不需要重启，旧的数据文件已经被替换
+	newStat := getStat(t, db)
+	assert.True(t, newStat.DataFileNum < stat.DataFileNum)
+	assert.True(t, newStat.ReclaimableSize < stat.ReclaimableSize)
+	assert.Equal(t, 2500, db.index.Size())
+	val, err := db.Get(utils.GetTestKey(2500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	for i := 2501; i < 5000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 快照和迭代器继续读取 merge 之前的数据
+	val, err = snap.Get(utils.GetTestKey(2500))
+	assert.Nil(t, err)
+	assert.Equal(t, values[2500], val)
+	val, err = snap.Get(utils.GetTestKey(4000))
+	assert.Nil(t, err)
+	assert.Equal(t, values[4000], val)
+	expected := make(map[string][]byte)
+	for i, value := range values {
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, expected[string(iter.Key())], val)
+		count++
+	}
+	assert.Equal(t, 2500, count)
+	assert.Equal(t, 1, len(db.retiredFiles))
+	snap.Release()
+	iter.Close()
+	assert.Equal(t, 0, len(db.retiredFiles))
+
+	// 再次 merge 之后重启
+	for i := 2600; i < 3000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2100, len(db2.ListKeys()))
+	for i := 3000; i < 5000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	val, err = db2.Get(utils.GetTestKey(2500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Merge_InstallOnline_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online-txn")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MergeInstallOnline = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 读过的 key 只是被 merge 移动了位置，提交成功
+	txn1 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("txn1"))
+	assert.Nil(t, err)
+
+	// 读过的 key 在 merge 之前被修改过，提交失败
+	txn2 := db.Begin()
+	_, err = txn2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(2), []byte("txn2"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("other"))
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn1"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("other"), val)
+}
+
+func TestDB_Merge_InstallOnline_ConcurrentRead(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online-read")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MergeInstallOnline = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := utils.RandomValue(128)
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// merge 期间读取不受影响
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+				for i := 2500; i < 5000; i += 97 {
+					if _, err := db.Get(utils.GetTestKey(i)); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		err = db.Merge()
+		assert.Nil(t, err)
+	}
+	close(done)
+	for g := 0; g < 4; g++ {
+		assert.Nil(t, <-errs)
+	}
+}
+
+// renameHookFS 每次重命名文件之前调用 hook
+type renameHookFS struct {
+	fio.FS
+	hook func()
+}
+
+func (fs *renameHookFS) Rename(oldPath, newPath string) error {
+	if fs.hook != nil {
+		fs.hook()
+	}
+	return fs.FS.Rename(oldPath, newPath)
+}
+
+func TestDB_Merge_InstallOnline_ReadDuringRename(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask-go-merge-online-rename"
+	opts.DataFileSize = 64 * 1024
+	opts.MergeInstallOnline = true
+	hookFS := &renameHookFS{FS: fio.NewMemFS()}
+	opts.FS = hookFS
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 5000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+
+	// 移动 merge 的文件时没有持有互斥锁，读写照常进行
+	var renames int
+	hookFS.hook = func() {
+		renames++
+		done := make(chan error, 1)
+		go func() {
+			val, err := db.Get(utils.GetTestKey(3000))
+			if err == nil && !bytes.Equal(values[3000], val) {
+				err = errors.New("unexpected value")
+			}
+			if err == nil {
+				err = db.Put(utils.GetTestKey(3001), values[3001])
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("reads are blocked while merge files are renamed")
+		}
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, renames > 1)
+	hookFS.hook = nil
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Merge_Incremental(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-incremental")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IncrementalMergeFiles = 2
+	opts.DataFileMergeRatio = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 5000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	// 删除标记和被覆盖的数据在不同的文件中
+	for i := 2000; i < 3000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	// 快照继续读取被 merge 的文件中的旧数据
+	snap := db.NewSnapshot()
+	oldValue := values[100]
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	assert.True(t, db.fileGarbage[0] > 0)
+
+	check := func(db *DB) {
+		assert.Equal(t, len(values), db.index.Size())
+		for i := 0; i < 5000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if value, ok := values[i]; ok {
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			} else {
+				assert.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+	}
+
+	stat := getStat(t, db)
+	err = db.Merge()
+	assert.Nil(t, err)
+	// 只有无效数据最多的两个文件被 merge，生成一个新的数据文件
+	newStat := getStat(t, db)
+	assert.Equal(t, stat.DataFileNum, newStat.DataFileNum)
+	assert.True(t, newStat.ReclaimableSize < stat.ReclaimableSize)
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	check(db)
+	val, err := snap.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, oldValue, val)
+	snap.Release()
+
+	// 继续 merge 直到没有文件达到阈值，删除标记对应的旧数据仍然存在时需要保留
+	for n := 0; n < 20; n++ {
+		if err := db.Merge(); err != nil {
+			assert.Equal(t, ErrMergeRatioUnreached, err)
+			break
+		}
+	}
+	assert.Equal(t, ErrMergeRatioUnreached, db.Merge())
+	check(db)
+	hintFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.DataHintFileSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(hintFiles) > 0)
+
+	// 重启之后从 hint 文件中加载新生成的数据文件的索引
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	check(db2)
+	err = db2.Put(utils.GetTestKey(2000), []byte("value"))
+	assert.Nil(t, err)
+	values[2000] = []byte("value")
+	check(db2)
+}
+
+func TestDB_Merge_IncrementalFileSize(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-incremental-size")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IncrementalMergeFiles = 8
+	opts.DataFileMergeRatio = 0.4
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 6000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 6000; i += 2 {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	check := func(db *DB) {
+		assert.Equal(t, len(values), len(db.ListKeys()))
+		for i, value := range values {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check(db)
+	// 有效数据超过一个文件的大小，写到多个新文件中，每个文件都不超过 DataFileSize
+	hintFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.DataHintFileSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(hintFiles) > 1)
+	dataFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+	assert.Nil(t, err)
+	for _, fileName := range dataFiles {
+		fileInfo, err := os.Stat(fileName)
+		assert.Nil(t, err)
+		assert.True(t, fileInfo.Size() <= opts.DataFileSize)
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	check(db2)
+}
+
+func TestDB_Merge_IncrementalExpired(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-incremental-expired")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IncrementalMergeFiles = 1
+	opts.DataFileMergeRatio = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 旧数据在第一个文件中，这个文件不会被 merge
+	err = db.Put([]byte("key"), []byte("old-value"))
+	assert.Nil(t, err)
+	for i := 0; db.activeFile.FileId == 0; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 新数据在第二个文件中，会过期，这个文件的无效数据最多
+	err = db.PutWithTTL([]byte("key"), []byte("new-value"), 50*time.Millisecond)
+	assert.Nil(t, err)
+	for i := 10000; db.activeFile.FileId == 1; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, 1))
+	assert.True(t, os.IsNotExist(err))
+	_, err = db.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后旧数据不会重新出现
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Merge_IncrementalAfterFinishedMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-finished")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 全量 merge 的结果还没有安装，增量 merge 不能删除 merge 目录
+	db.options.IncrementalMergeFiles = 2
+	db.options.DataFileMergeRatio = 0.3
+	err = db.Merge()
+	assert.Equal(t, ErrMergeNotInstalled, err)
+	assert.True(t, db.hasFinishedMerge())
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启时安装全量 merge 的结果
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(db2.ListKeys()))
+	for i, value := range values {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	_, err = os.Stat(db2.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_Merge_HintFileDataFileLost(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启时安装 merge 的结果，之后继续增量 merge，被删除的数据文件中的有效数据在新的文件中
+	opts.IncrementalMergeFiles = 2
+	opts.DataFileMergeRatio = 0.3
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 1500; i++ {
+		err := db2.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	err = db2.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(db3.ListKeys()))
+	for i, value := range values {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 没有新的 hint 文件可以说明 hint 索引文件中引用的数据文件为什么不存在，当作文件丢失
+	hintFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.DataHintFileSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(hintFiles) > 0)
+	for _, hintFile := range hintFiles {
+		err := os.Remove(hintFile)
+		assert.Nil(t, err)
+	}
+	_, err = Open(opts)
+	assert.Equal(t, ErrDataFileNotFound, err)
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 已经取消的 ctx 直接返回
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeContext(ctx, DefaultMergeOptions)
+	assert.Equal(t, context.Canceled, err)
+
+	// 处理完第一个数据文件之后取消，merge 目录被删除
+	ctx, cancel = context.WithCancel(context.Background())
+	var processed int
+	err = db.MergeContext(ctx, MergeOptions{Progress: func(progress MergeProgress) {
+		processed = progress.FilesProcessed
+		cancel()
+	}})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, processed)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 取消之后可以重新 merge，进度中的数据量和有效数据的比例
+	var progresses []MergeProgress
+	err = db.MergeContext(context.Background(), MergeOptions{Progress: func(progress MergeProgress) {
+		progresses = append(progresses, progress)
+	}})
+	assert.Nil(t, err)
+	assert.True(t, len(progresses) > 1)
+	last := progresses[len(progresses)-1]
+	assert.Equal(t, last.FilesTotal, last.FilesProcessed)
+	assert.Equal(t, len(progresses), last.FilesTotal)
+	assert.True(t, last.BytesWritten > 0)
+	assert.True(t, last.BytesRead > last.BytesWritten)
+	assert.True(t, last.LiveRatio > 0 && last.LiveRatio < 1)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2500, db2.index.Size())
+}
+
+func TestDB_Merge_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = BPlusTree
+	opts.DataFileMergeRatio = 0.5
+	opts.MergeInstallOnline = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 900; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	stat := getStat(t, db)
+	assert.True(t, stat.ReclaimableSize > 0)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启之后从保存的文件中恢复可回收的数据量
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, getStat(t, db2).ReclaimableSize)
+	assert.Equal(t, db.fileGarbage, db2.fileGarbage)
+	_, err = os.Stat(filepath.Join(dir, data.GarbageFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	// 模拟崩溃，没有保存统计信息，遍历数据文件重新统计
+	err = db2.index.Close()
+	assert.Nil(t, err)
+	err = db2.fileLock.Unlock()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, getStat(t, db3).ReclaimableSize)
+	assert.Equal(t, db.fileGarbage, db3.fileGarbage)
+
+	err = db3.Merge()
+	assert.Nil(t, err)
+	assert.True(t, getStat(t, db3).DataFileNum < stat.DataFileNum)
+	check := func(db *DB) {
+		assert.Equal(t, 100, len(db.ListKeys()))
+		for i := 900; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+	}
+	check(db3)
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 在线安装之后重启，B+ 树索引指向 merge 之后的位置
+	db4, err := Open(opts)
+	defer destroyDB(db4)
+	assert.Nil(t, err)
+	check(db4)
+
+	// 重启时安装 merge 的结果，根据 hint 文件更新 B+ 树索引
+	for i := 900; i < 950; i++ {
+		err := db4.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	for i := 950; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db4.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	db4.options.MergeInstallOnline = false
+	db4.options.DataFileMergeRatio = 0.1
+	err = db4.Merge()
+	assert.Nil(t, err)
+	err = db4.Close()
+	assert.Nil(t, err)
+
+	db5, err := Open(opts)
+	defer destroyDB(db5)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, index.BptreeIndexFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db5.ListKeys()))
+	for i := 900; i < 1000; i++ {
+		val, err := db5.Get(utils.GetTestKey(i))
+		if value, ok := values[i]; ok {
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		} else {
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+	}
+	err = db5.Close()
+	assert.Nil(t, err)
+}
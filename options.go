@@ -1,7 +1,9 @@
 package bitcask_go
 
 import (
+	"bitcask-go/fio"
 	"os"
+	"time"
 )
 
 type Options struct {
@@ -14,17 +16,57 @@ type Options struct {
 	// 每次写入是否持久化
 	SyncWrites bool
 
-	// 累计写到多少字节后进行持久化
+	// 累计写到多少字节后进行持久化，为 0 表示不按字节数持久化
 	BytesPerSync uint
 
+	// 定时持久化的间隔，为 0 表示不开启，写入较少时也能保证数据在一定时间内持久化
+	SyncInterval time.Duration
+
 	// 索引类型
 	IndexType IndexerType
 
+	// 是否预先分配数据文件的空间，减少文件碎片，写入时也不需要持久化文件大小的变化
+	PreallocateDataFile bool
+
+	// 读取数据文件时是否使用 O_DIRECT 绕过操作系统的页缓存，一般和 BlockCacheSize 一起使用
+	DirectIO bool
+
+	// 数据块缓存的大小，字节为单位，为 0 表示不使用缓存
+	BlockCacheSize int64
+
+	// 用户数据缓存的大小，字节为单位，按照数据的位置缓存读取到的 value，为 0 表示不使用缓存
+	ValueCacheSize int64
+
 	// 启动时是否使用 MMap 加载
 	MMapAtStartup bool
 
-	// 数据文件合并的阈值
+	// 数据文件合并的阈值，可以回收的数据量占数据文件总大小的比例达到该值时才进行 merge
 	DataFileMergeRatio float32
+
+	// merge 时预留的磁盘空间，字节为单位，可用空间不足以容纳 merge 之后的数据加上该值时不进行 merge
+	MergeFreeSpaceMargin int64
+
+	// 增量 merge 每次处理的数据文件数量，只选择无效数据最多并且比例达到 DataFileMergeRatio 的文件，结果总是在线安装
+	// 为 0 表示每次 merge 所有的旧数据文件
+	IncrementalMergeFiles int
+
+	// merge 完成之后是否立即替换旧的数据文件并更新索引，不需要等到下次启动，磁盘空间也会立即释放
+	MergeInstallOnline bool
+
+	// 后台检查是否需要 merge 的时间间隔，达到 DataFileMergeRatio 时自动进行 merge，为 0 表示不自动 merge
+	MergeCheckInterval time.Duration
+
+	// 是否以只读模式打开，只读模式下不会修改数据目录中的任何文件
+	// 只读实例之间共享文件锁，有读写实例正在使用目录时打开失败，返回 ErrDatabaseIsUsing
+	// 目录中没有文件锁时不加锁，也不会创建，此时不能阻止之后以读写模式打开
+	ReadOnly bool
+
+	// 是否将数据全部保存在内存中，不会读写磁盘，关闭之后数据丢失，不支持 B+ 树索引
+	// 没有指定 FS 时每次打开都会使用一个新的内存文件系统
+	InMemory bool
+
+	// 数据目录所在的文件系统，为空时使用操作系统的文件系统，B+ 树索引只支持操作系统的文件系统
+	FS fio.FS
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -45,6 +87,12 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// MergeOptions merge 配置项
+type MergeOptions struct {
+	// 每处理完一个数据文件回调一次，报告 merge 的进度，为空表示不需要
+	Progress func(progress MergeProgress)
+}
+
 type IndexerType = int8
 
 const (
@@ -77,3 +125,7 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchSize: 10000,
 	SyncWrites:   true,
 }
+
+var DefaultMergeOptions = MergeOptions{
+	Progress: nil,
+}
@@ -0,0 +1,131 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+)
+
+// Snapshot 数据库某一时刻的只读视图
+// 创建快照之后，被修改的key会在快照中保留修改之前的位置信息，由于数据文件是追加写的，旧的位置信息一直有效
+type Snapshot struct {
+	db       *DB
+	oldPos   map[string]*data.LogRecordPos //快照创建之后被修改的key，以及修改之前的位置信息，nil 表示当时不存在
+	moved    map[string]*movedPos          //快照创建之后被在线 merge 移动了位置的key
+	epoch    uint64                        //创建快照时的 merge 版本，用于读取被 merge 替换的旧数据文件
+	released bool
+}
+
+// movedPos key 被在线 merge 移动前后的位置信息，数据本身没有变化
+type movedPos struct {
+	from *data.LogRecordPos
+	to   *data.LogRecordPos
+}
+
+// NewSnapshot 创建当前数据库的快照，使用完之后需要调用 Release 释放
+func (db *DB) NewSnapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snap := &Snapshot{
+		db:     db,
+		oldPos: make(map[string]*data.LogRecordPos),
+		moved:  make(map[string]*movedPos),
+		epoch:  db.mergeEpoch,
+	}
+	db.snapshots[snap] = struct{}{}
+	return snap
+}
+
+// Get 读取快照创建时key对应的value
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.getPos(key)
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	return s.db.getValueByPosition(logRecordPos, s.epoch)
+}
+
+// NewIterator 创建快照的迭代器
+func (s *Snapshot) NewIterator(options IteratorOptions) (*Iterator, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	// 以当前的索引为基础，还原快照创建时的索引数据
+	view := index.NewBTree()
+	indexIter := s.db.index.Iterator(false)
+	for indexIter.Rewind(); indexIter.Valid(); indexIter.Next() {
+		// B+ 树迭代器返回的 key 只在事务内有效，需要拷贝一份
+		key := append([]byte(nil), indexIter.Key()...)
+		view.Put(key, indexIter.Value())
+	}
+	indexIter.Close()
+	for key, pos := range s.oldPos {
+		if pos == nil {
+			view.Delete([]byte(key))
+		} else {
+			view.Put([]byte(key), pos)
+		}
+	}
+	return s.db.registerIterator(view.Iterator(options.Reverse), options, s.epoch), nil
+}
+
+// Release 释放快照，之后的修改不再需要为该快照保留旧的位置信息
+func (s *Snapshot) Release() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.release()
+}
+
+// release 释放快照，在访问此方法之前必须持有互斥锁
+func (s *Snapshot) release() {
+	delete(s.db.snapshots, s)
+	s.oldPos = nil
+	s.moved = nil
+	s.released = true
+	s.db.releaseRetiredFiles()
+}
+
+// getPos 获取快照中key的位置信息，在访问此方法之前必须持有互斥锁
+func (s *Snapshot) getPos(key []byte) *data.LogRecordPos {
+	if pos, ok := s.oldPos[string(key)]; ok {
+		return pos
+	}
+	return s.db.index.Get(key)
+}
+
+// saveOldPos 记录key第一次被修改之前的位置信息，在访问此方法之前必须持有互斥锁
+func (s *Snapshot) saveOldPos(key []byte, pos *data.LogRecordPos) {
+	if _, ok := s.oldPos[string(key)]; ok {
+		return
+	}
+	s.oldPos[string(key)] = pos
+}
+
+// saveMovedPos 记录key被在线 merge 从 from 移动到 to，在访问此方法之前必须持有互斥锁
+func (s *Snapshot) saveMovedPos(key []byte, from, to *data.LogRecordPos) {
+	s.saveOldPos(key, from)
+	// 多次 merge 移动同一条数据时，合并成一次移动
+	if moved, ok := s.moved[string(key)]; ok && isSamePos(moved.to, from) {
+		moved.to = to
+		return
+	}
+	s.moved[string(key)] = &movedPos{from: from, to: to}
+}
+
+// isMoved 判断key从 from 到 to 的变化是否只是被在线 merge 移动了位置，在访问此方法之前必须持有互斥锁
+func (s *Snapshot) isMoved(key []byte, from, to *data.LogRecordPos) bool {
+	moved, ok := s.moved[string(key)]
+	return ok && isSamePos(moved.from, from) && isSamePos(moved.to, to)
+}
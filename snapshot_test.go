@@ -0,0 +1,72 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+
+	snap := db.NewSnapshot()
+
+	// 快照创建之后的修改
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+	wb, err := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, err)
+	err = wb.Put(utils.GetTestKey(4), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	v1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, v1)
+	v2, err := snap.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, v2)
+	_, err = snap.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = snap.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 当前数据库能看到最新的数据
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	v3, err := db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.NotNil(t, v3)
+
+	iter, err := snap.NewIterator(DefaultIteratorOptions)
+	assert.Nil(t, err)
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)}, keys)
+
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.Equal(t, 0, len(db.snapshots))
+}
@@ -0,0 +1,147 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sync"
+)
+
+// Txn 乐观读写事务
+// 读取基于事务开始时的快照，提交时如果读过的key在事务开始之后被修改过，则提交失败
+type Txn struct {
+	db            *DB
+	mu            *sync.Mutex
+	snapshot      *Snapshot
+	pendingWrites map[string]*data.LogRecord    //事务中暂存的写入
+	readKeys      map[string]*data.LogRecordPos //事务中读过的key，以及读取时的位置信息
+	closed        bool
+}
+
+// Begin 开启一个新的事务
+func (db *DB) Begin() *Txn {
+	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot use transaction ,seq no file not exists")
+	}
+	return &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		snapshot:      db.NewSnapshot(),
+		pendingWrites: make(map[string]*data.LogRecord),
+		readKeys:      make(map[string]*data.LogRecordPos),
+	}
+}
+
+// Get 读取数据，优先读取事务中尚未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	// 读取自己的写入
+	if logRecord, ok := txn.pendingWrites[string(key)]; ok {
+		if logRecord.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return logRecord.Value, nil
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+	logRecordPos := txn.snapshot.getPos(key)
+	txn.readKeys[string(key)] = logRecordPos
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueByPosition(logRecordPos, txn.snapshot.epoch)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if txn.db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if txn.db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// Commit 提交事务，读过的key被其他写入修改过时返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	if len(txn.pendingWrites) == 0 {
+		txn.close()
+		return nil
+	}
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+	// 冲突检测之后再释放快照，需要用到快照中记录的 merge 移动过的位置
+	txn.closed = true
+	defer txn.snapshot.release()
+
+	// 冲突检测，位置信息不同说明key在事务开始之后被修改过，只是被 merge 移动了位置的除外
+	for key, readPos := range txn.readKeys {
+		curPos := txn.db.index.Get([]byte(key))
+		if !isSamePos(readPos, curPos) && !txn.snapshot.isMoved([]byte(key), readPos, curPos) {
+			return ErrTxnConflict
+		}
+	}
+	return txn.db.commitTxnRecords(txn.pendingWrites)
+}
+
+// Rollback 回滚事务，丢弃所有暂存的写入
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return
+	}
+	txn.close()
+}
+
+func (txn *Txn) close() {
+	txn.closed = true
+	txn.snapshot.Release()
+}
+
+func isSamePos(a, b *data.LogRecordPos) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Fid == b.Fid && a.Offset == b.Offset
+}
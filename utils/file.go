@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bitcask-go/fio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DirSize 获取一个目录的大小
+func DirSize(fs fio.FS, dirPath string) (int64, error) {
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirSize, err := DirSize(fs, filepath.Join(dirPath, entry.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += dirSize
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// CopyFile 拷贝文件，目标文件存在时会被覆盖，并将内容持久化
+func CopyFile(fs fio.FS, src, dest string) error {
+	srcFile, err := fs.OpenFile(src, fio.ReadOnlyFIO)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	if err := fs.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	destFile, err := fs.OpenFile(dest, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	buf := make([]byte, 1024*1024)
+	var offset int64
+	for {
+		n, err := srcFile.Read(buf, offset)
+		if n > 0 {
+			if _, err := destFile.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return destFile.Sync()
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirSize(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-dirsize")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	err := os.WriteFile(filepath.Join(dir, "a.data"), []byte("bitcask-go"), 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, "b.data"), []byte("kv"), 0644)
+	assert.Nil(t, err)
+
+	size, err := DirSize(fio.OSFS, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), size)
+}